@@ -0,0 +1,259 @@
+package speed
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// BitOperation BitOp的运算类型
+type BitOperation uint8
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// maxBitOffset 位图最大偏移 与redis一致为512MB
+const maxBitOffset = 1<<32 - 1
+
+var (
+	// ErrBitOffset 位偏移超出范围
+	ErrBitOffset = errors.New("bit offset is out of range")
+	// ErrBitValue 位的值只能是0或1
+	ErrBitValue = errors.New("bit is not an integer or out of range")
+	// ErrBitOpNot BitNot只能有一个源key
+	ErrBitOpNot = errors.New("BITOP NOT must be called with a single source key")
+)
+
+// bitmapValue SetBit写入的位图 由缓存独占，SetBit原地修改，Get等读取时返回[]byte副本
+type bitmapValue []byte
+
+// SetBit 设置位图offset位置的值并返回原来的值 位图保存为k-v中的[]byte，key不存在时创建且永不过期
+// 值是调用方Set的[]byte时先复制一份，之后原地修改，只在长度不够时重新分配；Get得到的是副本，不会被之后的SetBit修改
+func (c *cache) SetBit(k string, offset uint64, value int) (int, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	if offset > maxBitOffset {
+		return 0, ErrBitOffset
+	}
+	if value != 0 && value != 1 {
+		return 0, ErrBitValue
+	}
+	c.kv_mu.Lock()
+	item, ok := c.kvItems[k]
+	bitmap, err := toBitmap(item.Object)
+	if err != nil {
+		c.kv_mu.Unlock()
+		return 0, err
+	}
+	if !ok {
+		item = KVItem{Key: k}
+	}
+	i, shift := offset/8, 7-offset%8
+	if _, owned := item.Object.(bitmapValue); !owned { //调用方Set的切片不能修改
+		bitmap = append([]byte{}, bitmap...)
+	}
+	if n := uint64(len(bitmap)); n <= i { //长度不够时扩容，由append按倍数预留容量
+		bitmap = append(bitmap, make([]byte, i+1-n)...)
+	}
+	old := int(bitmap[i]>>shift) & 1
+	if value == 1 {
+		bitmap[i] |= 1 << shift
+	} else {
+		bitmap[i] &^= 1 << shift
+	}
+	item.Object = bitmapValue(bitmap)
+	item.Version = c.nextVersion()
+	c.kvItems[k] = item
+	c.kv_mu.Unlock()
+	c.stats.set(TypeKV)
+	c.notify(writeEvent(ok), TypeKV, k)
+	return old, nil
+}
+
+// GetBit 获取位图offset位置的值 key不存在或超出长度时为0
+func (c *cache) GetBit(k string, offset uint64) (int, error) {
+	c.kv_mu.RLock()
+	defer c.kv_mu.RUnlock()
+	bitmap, err := c.bitmap(k)
+	if err != nil {
+		return 0, err
+	}
+	i := offset / 8
+	if uint64(len(bitmap)) <= i {
+		return 0, nil
+	}
+	return int(bitmap[i]>>(7-offset%8)) & 1, nil
+}
+
+// BitCount 统计位图[start, end]字节范围内值为1的位数 负数表示从末尾开始，end为-1表示到最后一个字节
+func (c *cache) BitCount(k string, start, end int64) (int, error) {
+	c.kv_mu.RLock()
+	defer c.kv_mu.RUnlock()
+	bitmap, err := c.bitmap(k)
+	if err != nil {
+		return 0, err
+	}
+	start, end, ok := byteRange(len(bitmap), start, end)
+	if !ok {
+		return 0, nil
+	}
+	n := 0
+	for _, b := range bitmap[start : end+1] {
+		n += bits.OnesCount8(b)
+	}
+	return n, nil
+}
+
+// BitPos 查找位图[start, end]字节范围内第一个值为bit的位，没有找到返回-1
+// 查找0且end为-1时，全部为1则返回位图长度之后的第一位，与redis一致
+func (c *cache) BitPos(k string, bit int, start, end int64) (int64, error) {
+	if bit != 0 && bit != 1 {
+		return 0, ErrBitValue
+	}
+	c.kv_mu.RLock()
+	defer c.kv_mu.RUnlock()
+	bitmap, err := c.bitmap(k)
+	if err != nil {
+		return 0, err
+	}
+	toEnd := end == -1
+	if len(bitmap) == 0 {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	s, e, ok := byteRange(len(bitmap), start, end)
+	if !ok {
+		return -1, nil
+	}
+	for i := s; i <= e; i++ {
+		b := bitmap[i]
+		if bit == 0 {
+			b = ^b
+		}
+		if b != 0 {
+			return i*8 + int64(bits.LeadingZeros8(b)), nil
+		}
+	}
+	if bit == 0 && toEnd {
+		return int64(len(bitmap)) * 8, nil
+	}
+	return -1, nil
+}
+
+// BitOp 对多个位图做按位运算并把结果写入dest 返回结果的字节数
+// 长度不同时较短的位图按0补齐，不存在的key视为空位图，dest原有的过期时间会被清除
+func (c *cache) BitOp(op BitOperation, dest string, keys ...string) (int, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	if op == BitNot && len(keys) != 1 {
+		return 0, ErrBitOpNot
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	c.kv_mu.Lock()
+	sources := make([][]byte, len(keys))
+	maxLen := 0
+	for i, key := range keys {
+		bitmap, err := toBitmap(c.kvItems[key].Object)
+		if err != nil {
+			c.kv_mu.Unlock()
+			return 0, err
+		}
+		sources[i] = bitmap
+		if len(bitmap) > maxLen {
+			maxLen = len(bitmap)
+		}
+	}
+	res := make([]byte, maxLen)
+	for i := range res {
+		var v byte
+		for j, src := range sources {
+			var b byte
+			if i < len(src) {
+				b = src[i]
+			}
+			switch {
+			case op == BitNot:
+				v = ^b
+			case j == 0:
+				v = b
+			case op == BitAnd:
+				v &= b
+			case op == BitOr:
+				v |= b
+			case op == BitXor:
+				v ^= b
+			}
+		}
+		res[i] = v
+	}
+	after := c.kvStore(KVItem{Object: bitmapValue(res), Key: dest}, 0)
+	c.kv_mu.Unlock()
+	after()
+	return len(res), nil
+}
+
+// bitmap 获取k-v中的位图 调用方需持有kv_mu锁
+func (c *cache) bitmap(k string) ([]byte, error) {
+	item, ok := c.kvItems[k]
+	c.stats.lookup(TypeKV, ok)
+	return toBitmap(item.Object)
+}
+
+// toBitmap 值必须是[]byte，nil视为空位图
+func toBitmap(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return b, nil
+	case bitmapValue:
+		return b, nil
+	}
+	return nil, ErrWrongType
+}
+
+// kvObject 将位图还原为[]byte 用于已经从kvItems移除、不会再被SetBit修改的值
+func kvObject(v interface{}) interface{} {
+	if b, ok := v.(bitmapValue); ok {
+		return []byte(b)
+	}
+	return v
+}
+
+// kvCopy 读取仍在kvItems中的值 位图会被SetBit原地修改，返回副本 调用方需持有kv_mu锁
+func kvCopy(v interface{}) interface{} {
+	if b, ok := v.(bitmapValue); ok {
+		return append([]byte{}, b...)
+	}
+	return v
+}
+
+// byteRange 将支持负数的字节范围转换为[start, end]下标
+func byteRange(n int, start, end int64) (int64, int64, bool) {
+	l := int64(n)
+	if start < 0 {
+		start += l
+	}
+	if end < 0 {
+		end += l
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= l {
+		end = l - 1
+	}
+	if l == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -0,0 +1,118 @@
+package speed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	for _, id := range []uint64{1, 7, 100} {
+		if old, err := c.SetBit("active:mon", id, 1); err != nil || old != 0 {
+			t.Fatalf("SetBit(%d) = %d, %v", id, old, err)
+		}
+	}
+	if old, _ := c.SetBit("active:mon", 7, 1); old != 1 {
+		t.Fatal("SetBit did not return the previous value")
+	}
+	if v, _ := c.GetBit("active:mon", 100); v != 1 {
+		t.Fatal("GetBit(100) = 0")
+	}
+	if v, _ := c.GetBit("active:mon", 1000); v != 0 {
+		t.Fatal("GetBit beyond the end = 1")
+	}
+	if n, _ := c.BitCount("active:mon", 0, -1); n != 3 {
+		t.Fatalf("BitCount = %d, want 3", n)
+	}
+	if n, _ := c.BitCount("active:mon", 1, -1); n != 1 {
+		t.Fatalf("BitCount(1, -1) = %d, want 1", n)
+	}
+	if pos, _ := c.BitPos("active:mon", 1, 0, -1); pos != 1 {
+		t.Fatalf("BitPos(1) = %d, want 1", pos)
+	}
+	if pos, _ := c.BitPos("active:mon", 0, 0, -1); pos != 0 {
+		t.Fatalf("BitPos(0) = %d, want 0", pos)
+	}
+
+	c.SetBit("active:tue", 7, 1)
+	c.SetBit("active:tue", 8, 1)
+	if n, _ := c.BitOp(BitAnd, "both", "active:mon", "active:tue"); n != 13 {
+		t.Fatalf("BitOp length = %d, want 13", n)
+	}
+	if n, _ := c.BitCount("both", 0, -1); n != 1 {
+		t.Fatalf("AND count = %d, want 1", n)
+	}
+	c.BitOp(BitOr, "either", "active:mon", "active:tue")
+	if n, _ := c.BitCount("either", 0, -1); n != 4 {
+		t.Fatalf("OR count = %d, want 4", n)
+	}
+	c.BitOp(BitXor, "one", "active:mon", "active:tue")
+	if n, _ := c.BitCount("one", 0, -1); n != 3 {
+		t.Fatalf("XOR count = %d, want 3", n)
+	}
+	c.BitOp(BitNot, "inactive", "active:tue")
+	if n, _ := c.BitCount("inactive", 0, -1); n != 14 {
+		t.Fatalf("NOT count = %d, want 14", n)
+	}
+	if _, err := c.BitOp(BitNot, "x", "a", "b"); err != ErrBitOpNot {
+		t.Fatalf("err = %v, want ErrBitOpNot", err)
+	}
+
+	c.Set("name", "城邦", 0, false)
+	if _, err := c.SetBit("name", 1, 1); err != ErrWrongType {
+		t.Fatalf("err = %v, want ErrWrongType", err)
+	}
+	if _, err := c.SetBit("x", 0, 2); err != ErrBitValue {
+		t.Fatalf("err = %v, want ErrBitValue", err)
+	}
+}
+
+func TestBitmapCopyOnWrite(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	src := []byte{0}
+	c.Set("flags", src, 0, false)
+	v, _ := c.Get("flags")
+	done := make(chan struct{})
+	go func() { //与SetBit并发读取Get的结果，-race下不能报错
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = v.([]byte)[0]
+		}
+	}()
+	for i := uint64(0); i < 8; i++ {
+		c.SetBit("flags", i, 1)
+	}
+	<-done
+	if src[0] != 0 || v.([]byte)[0] != 0 {
+		t.Fatal("SetBit modified a slice owned by the caller")
+	}
+	if n, _ := c.BitCount("flags", 0, -1); n != 8 {
+		t.Fatalf("BitCount = %d, want 8", n)
+	}
+	if v, _ := c.Get("flags"); !bytes.Equal(v.([]byte), []byte{0xff}) {
+		t.Fatalf("Get = %v", v)
+	}
+
+	bitmap := func() bitmapValue { //直接读取内部的位图，检查是否重新分配
+		c.kv_mu.RLock()
+		defer c.kv_mu.RUnlock()
+		return c.kvItems["flags"].Object.(bitmapValue)
+	}
+	before := bitmap()
+	c.SetBit("flags", 3, 0)
+	if after := bitmap(); &after[0] != &before[0] {
+		t.Fatal("SetBit reallocated a bitmap that did not need to grow")
+	}
+	c.SetBit("flags", 100, 1)
+	if after := bitmap(); len(after) != 13 || after[12] != 0x08 || after[0] != 0xef {
+		t.Fatalf("grown bitmap = %v", after)
+	}
+}
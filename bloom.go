@@ -0,0 +1,327 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	DefaultFilterErrorRate = 0.01 //默认误判率
+	DefaultFilterCapacity  = 100  //默认容量
+	filterExpansion        = 2    //容量用完后新增一层，新层容量翻倍
+	filterTightening       = 0.5  //新层误判率减半，使整体误判率收敛
+	bloomMagic             = "SPBF"
+	maxBloomHashes         = 64 //哈希函数个数上限，再多对误判率已经没有意义
+	filterVersion          = 1
+)
+
+var (
+	ErrFilterExists = errors.New("filter already exists")
+	ErrFilterParams = errors.New("filter error rate must be in (0, 1) and capacity greater than 0")
+	ErrFilterData   = errors.New("invalid filter data")
+)
+
+// FilterInfo 布隆/布谷鸟过滤器信息
+type FilterInfo struct {
+	Capacity  uint64  //所有层容量之和
+	Items     uint64  //已添加元素数量
+	Layers    int     //层数
+	Bytes     int     //占用内存
+	ErrorRate float64 //创建时的误判率
+}
+
+// bloomLayer 单层布隆过滤器 m位 k个哈希函数
+type bloomLayer struct {
+	capacity  uint64
+	count     uint64
+	errorRate float64
+	k         uint32
+	m         uint64
+	bits      []uint64
+}
+
+func newBloomLayer(errorRate float64, capacity uint64) *bloomLayer {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > maxBloomHashes {
+		k = maxBloomHashes
+	}
+	return &bloomLayer{
+		capacity:  capacity,
+		errorRate: errorRate,
+		k:         k,
+		m:         m,
+		bits:      make([]uint64, (m+63)/64),
+	}
+}
+
+// test 双重哈希 第i个位置为h1+i*h2
+func (l *bloomLayer) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < uint64(l.k); i++ {
+		pos := (h1 + i*h2) % l.m
+		if l.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	for i := uint64(0); i < uint64(l.k); i++ {
+		pos := (h1 + i*h2) % l.m
+		l.bits[pos/64] |= 1 << (pos % 64)
+	}
+	l.count++
+}
+
+// bloomItem 可扩容布隆过滤器 当前层满后追加新层
+type bloomItem struct {
+	layers []*bloomLayer
+}
+
+func newBloomItem(errorRate float64, capacity uint64) *bloomItem {
+	return &bloomItem{layers: []*bloomLayer{newBloomLayer(errorRate, capacity)}}
+}
+
+func bloomHash(item interface{}) (uint64, uint64) {
+	h := hash64(item)
+	h2 := h>>33 | h<<31
+	h2 ^= h2 >> 29
+	h2 *= 0x94d049bb133111eb
+	return h, h2 | 1
+}
+
+func (b *bloomItem) exists(h1, h2 uint64) bool {
+	for _, l := range b.layers {
+		if l.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bloomItem) add(item interface{}) bool {
+	h1, h2 := bloomHash(item)
+	if b.exists(h1, h2) {
+		return false
+	}
+	last := b.layers[len(b.layers)-1]
+	if last.count >= last.capacity {
+		last = newBloomLayer(last.errorRate*filterTightening, last.capacity*filterExpansion)
+		b.layers = append(b.layers, last)
+	}
+	last.add(h1, h2)
+	return true
+}
+
+func (b *bloomItem) info() FilterInfo {
+	info := FilterInfo{Layers: len(b.layers), ErrorRate: b.layers[0].errorRate}
+	for _, l := range b.layers {
+		info.Capacity += l.capacity
+		info.Items += l.count
+		info.Bytes += len(l.bits) * 8
+	}
+	return info
+}
+
+func (b *bloomItem) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(bloomMagic)
+	buf.WriteByte(filterVersion)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(b.layers)))
+	for _, l := range b.layers {
+		binary.Write(&buf, binary.LittleEndian, []uint64{l.capacity, l.count, math.Float64bits(l.errorRate), uint64(l.k), l.m})
+		binary.Write(&buf, binary.LittleEndian, l.bits)
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *bloomItem) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if !readHeader(r, bloomMagic) {
+		return ErrFilterData
+	}
+	var n uint32
+	if binary.Read(r, binary.LittleEndian, &n) != nil || n == 0 {
+		return ErrFilterData
+	}
+	layers := make([]*bloomLayer, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var h [5]uint64
+		if binary.Read(r, binary.LittleEndian, &h) != nil {
+			return ErrFilterData
+		}
+		l := &bloomLayer{capacity: h[0], count: h[1], errorRate: math.Float64frombits(h[2]), k: uint32(h[3]), m: h[4]}
+		if !validFilterParams(l.errorRate, l.capacity) || l.count > l.capacity || l.m == 0 || l.k == 0 || h[3] > maxBloomHashes {
+			return ErrFilterData
+		}
+		words := l.m / 64 //(m+63)/64在m接近上限时会溢出
+		if l.m%64 != 0 {
+			words++
+		}
+		if words > uint64(r.Len())/8 {
+			return ErrFilterData
+		}
+		l.bits = make([]uint64, words)
+		if binary.Read(r, binary.LittleEndian, l.bits) != nil {
+			return ErrFilterData
+		}
+		layers = append(layers, l)
+	}
+	if r.Len() != 0 {
+		return ErrFilterData
+	}
+	b.layers = layers
+	return nil
+}
+
+func readHeader(r *bytes.Reader, magic string) bool {
+	head := make([]byte, len(magic)+1)
+	if _, err := r.Read(head); err != nil {
+		return false
+	}
+	return string(head[:len(magic)]) == magic && head[len(magic)] == filterVersion
+}
+
+func validFilterParams(errorRate float64, capacity uint64) bool {
+	return errorRate > 0 && errorRate < 1 && capacity > 0
+}
+
+// BFReserve 创建布隆过滤器 errorRate为误判率 capacity为预计元素数量，超出容量后自动扩容
+func (c *cache) BFReserve(key string, errorRate float64, capacity uint64) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if !validFilterParams(errorRate, capacity) {
+		return ErrFilterParams
+	}
+	c.bloom_mu.Lock()
+	if _, ok := c.bloomItems[key]; ok {
+		c.bloom_mu.Unlock()
+		return ErrFilterExists
+	}
+	c.bloomItems[key] = newBloomItem(errorRate, capacity)
+	c.bloom_mu.Unlock()
+	c.stats.set(TypeBloom)
+	c.notify(EventSet, TypeBloom, key)
+	return nil
+}
+
+// BFAdd 添加元素 key不存在时按默认参数创建，元素可能已存在时返回false
+func (c *cache) BFAdd(key string, item interface{}) bool {
+	if c.isClosed() {
+		return false
+	}
+	return c.BFMAdd(key, item)[0]
+}
+
+// BFMAdd 批量添加元素
+func (c *cache) BFMAdd(key string, items ...interface{}) []bool {
+	if c.isClosed() {
+		return nil
+	}
+	res := make([]bool, len(items))
+	c.bloom_mu.Lock()
+	b, ok := c.bloomItems[key]
+	if !ok {
+		b = newBloomItem(DefaultFilterErrorRate, DefaultFilterCapacity)
+		c.bloomItems[key] = b
+	}
+	changed := !ok
+	for i, item := range items {
+		res[i] = b.add(item)
+		changed = changed || res[i]
+	}
+	c.bloom_mu.Unlock()
+	c.stats.set(TypeBloom)
+	if changed {
+		c.notify(writeEvent(ok), TypeBloom, key)
+	}
+	return res
+}
+
+// BFExists 判断元素是否可能存在 返回false时一定不存在
+func (c *cache) BFExists(key string, item interface{}) bool {
+	return c.BFMExists(key, item)[0]
+}
+
+// BFMExists 批量判断元素是否可能存在
+func (c *cache) BFMExists(key string, items ...interface{}) []bool {
+	res := make([]bool, len(items))
+	c.bloom_mu.RLock()
+	b, ok := c.bloomItems[key]
+	if ok {
+		for i, item := range items {
+			res[i] = b.exists(bloomHash(item))
+		}
+	}
+	c.bloom_mu.RUnlock()
+	c.stats.lookup(TypeBloom, ok)
+	return res
+}
+
+// BFInfo 获取布隆过滤器信息
+func (c *cache) BFInfo(key string) (FilterInfo, bool) {
+	c.bloom_mu.RLock()
+	defer c.bloom_mu.RUnlock()
+	b, ok := c.bloomItems[key]
+	if !ok {
+		return FilterInfo{}, false
+	}
+	return b.info(), true
+}
+
+// BFDump 序列化布隆过滤器 用于快照保存
+func (c *cache) BFDump(key string) ([]byte, bool) {
+	c.bloom_mu.RLock()
+	defer c.bloom_mu.RUnlock()
+	b, ok := c.bloomItems[key]
+	if !ok {
+		return nil, false
+	}
+	data, _ := b.MarshalBinary()
+	return data, true
+}
+
+// BFRestore 从BFDump的结果恢复布隆过滤器 覆盖已存在的key
+func (c *cache) BFRestore(key string, data []byte) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	b := &bloomItem{}
+	if err := b.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	c.bloom_mu.Lock()
+	_, ok := c.bloomItems[key]
+	c.bloomItems[key] = b
+	c.bloom_mu.Unlock()
+	c.stats.set(TypeBloom)
+	c.notify(writeEvent(ok), TypeBloom, key)
+	return nil
+}
+
+// BFDel 删除布隆过滤器
+func (c *cache) BFDel(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.bloom_mu.Lock()
+	_, ok := c.bloomItems[key]
+	if ok {
+		delete(c.bloomItems, key)
+	}
+	c.bloom_mu.Unlock()
+	if ok {
+		c.stats.delete(TypeBloom)
+		c.notify(EventDelete, TypeBloom, key)
+	}
+	return ok
+}
@@ -0,0 +1,108 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.BFReserve("ids", 0.01, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BFReserve("ids", 0.01, 1000); err != ErrFilterExists {
+		t.Fatalf("err = %v, want ErrFilterExists", err)
+	}
+	if err := c.BFReserve("bad", 1, 1000); err != ErrFilterParams {
+		t.Fatalf("err = %v, want ErrFilterParams", err)
+	}
+	const n = 5000 //超过容量，触发扩容
+	for i := 0; i < n; i++ {
+		c.BFAdd("ids", "id-"+strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ {
+		if !c.BFExists("ids", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("false negative for id-%d", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if c.BFExists("ids", "id-"+strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Fatalf("false positive rate %.4f", rate)
+	}
+	info, ok := c.BFInfo("ids")
+	if !ok || info.Layers < 2 || info.Items > n || info.Capacity < n {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if res := c.BFMExists("ids", "id-1", "missing"); !res[0] {
+		t.Fatalf("BFMExists = %v", res)
+	}
+	if c.BFExists("none", "x") {
+		t.Fatal("missing filter reported an item")
+	}
+	if !c.BFAdd("auto", "x") || c.BFAdd("auto", "x") {
+		t.Fatal("BFAdd should create the filter and report duplicates")
+	}
+
+	data, ok := c.BFDump("ids")
+	if !ok {
+		t.Fatal("BFDump failed")
+	}
+	if err := c.BFRestore("copy", data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i += 97 {
+		if !c.BFExists("copy", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("restored filter lost id-%d", i)
+		}
+	}
+	if err := c.BFRestore("copy", data[:len(data)-1]); err != ErrFilterData {
+		t.Fatalf("err = %v, want ErrFilterData", err)
+	}
+	if !c.BFDel("copy") || c.BFExists("copy", "id-0") {
+		t.Fatal("BFDel failed")
+	}
+}
+
+func TestBloomRestoreInvalid(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	layer := func(capacity, count uint64, errorRate float64, k, m uint64, words int) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(bloomMagic)
+		buf.WriteByte(filterVersion)
+		binary.Write(&buf, binary.LittleEndian, uint32(1))
+		binary.Write(&buf, binary.LittleEndian, []uint64{capacity, count, math.Float64bits(errorRate), k, m})
+		binary.Write(&buf, binary.LittleEndian, make([]uint64, words))
+		return buf.Bytes()
+	}
+	if err := c.BFRestore("ok", layer(100, 0, 0.01, 7, 960, 15)); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]byte{
+		"m overflow":      layer(100, 0, 0.01, 7, math.MaxUint64, 0),
+		"too many hashes": layer(100, 0, 0.01, 1<<20, 960, 15),
+		"zero capacity":   layer(0, 0, 0.01, 7, 960, 15),
+		"error rate":      layer(100, 0, 1.5, 7, 960, 15),
+		"count":           layer(100, 101, 0.01, 7, 960, 15),
+	}
+	for name, data := range cases {
+		if err := c.BFRestore(name, data); err != ErrFilterData {
+			t.Fatalf("%s: err = %v, want ErrFilterData", name, err)
+		}
+	}
+}
@@ -2,26 +2,140 @@ package speed
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrNotInteger 自增操作的值不是整数
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrTxAborted 事务监视的key在Exec之前被修改
+	ErrTxAborted = errors.New("transaction aborted: watched key changed")
+	// ErrWrongType key中保存的值类型与命令不匹配
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+)
+
 type Cache struct {
 	*cache
 }
 
 type cache struct {
-	kvItems        map[string]KVItem   //k-v结构
-	kv_mu          sync.RWMutex        //读写锁
-	hashItems      map[string]HASHItem //hash结构
-	hash_mu        sync.RWMutex
-	setItems       map[string]SetItem //集合
-	set_mu         sync.RWMutex
-	deleteCallBack func(string, interface{}) //回调事件  超时或者删除的时候触发回调
-	snowflake      *Node                     //雪花算法生成key
-	timeWheel      *TimeWheel                //时间轮  过期调用
-	ctx            context.Context
-	cancel         context.CancelFunc
+	stats         cacheStats                      //统计计数 放在首位保证atomic操作64位对齐
+	callBackCost  [dataTypeCount]latencyHistogram //回调耗时分布
+	version       uint64                          //版本号 每次写入k-v或hash时递增
+	delVersion    uint64                          //最近一次删除k-v或hash时的版本号 用于事务监视不存在的key
+	kvItems       map[string]KVItem               //k-v结构
+	kv_mu         sync.RWMutex                    //读写锁
+	hashItems     map[string]HASHItem             //hash结构
+	hash_mu       sync.RWMutex
+	setItems      map[string]SetItem //集合
+	set_mu        sync.RWMutex
+	streamItems   map[string]*streamItem   //流
+	streamWaiters map[string]*streamWaiter //等待尚不存在的流的阻塞读取 受stream_mu保护
+	stream_mu     sync.RWMutex
+	hllItems      map[string]*hllItem //HyperLogLog
+	hll_mu        sync.RWMutex
+	geoItems      map[string]*geoItem //地理位置
+	geo_mu        sync.RWMutex
+	bloomItems    map[string]*bloomItem //布隆过滤器
+	bloom_mu      sync.RWMutex
+	cuckooItems   map[string]*cuckooItem //布谷鸟过滤器
+	cuckoo_mu     sync.RWMutex
+	cmsItems      map[string]*cmsItem //Count-Min Sketch
+	cms_mu        sync.RWMutex
+	topKItems     map[string]*topKItem //Top-K
+	topk_mu       sync.RWMutex
+	limitItems    map[string]*limitItem //限流状态
+	limit_mu      sync.Mutex
+	lockItems     map[string]*lockItem //锁
+	lockFence     uint64               //最近发放的防护令牌
+	lock_mu       sync.Mutex
+	semItems      map[string]*leaseItem //信号量
+	rwLockItems   map[string]*leaseItem //读写锁
+	lease_mu      sync.Mutex
+	hotKeys       *hotKeys                      //热点key统计 未开启时为nil
+	deleteHandler atomic.Value                  //回调事件 func(DeleteEvent) 超时、删除、覆盖或清空的时候触发回调
+	dispatcher    *dispatcher                   //回调分发 在工作协程中执行回调
+	snowflake     *Node                         //雪花算法生成key
+	timeWheel     *TimeWheel                    //时间轮  过期调用
+	clock         Clock                         //时间来源 过期时间和租期都按它计算
+	events        eventBus                      //键空间事件订阅
+	pubsub        *pubSub                       //发布订阅
+	closed        int32                         //Close或Stop之后为1 通过atomic读写
+	closing       chan struct{}                 //Close时关闭 通知run退出
+	runDone       chan struct{}                 //run退出后关闭
+	closeHooks    []func(context.Context) error //Close时执行的持久化钩子
+	closeCallBack bool                          //Close时对未过期的数据触发回调
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+// timerKey 时间轮定时器标识 不同数据类型的key互不冲突
+type timerKey struct {
+	typ DataType
+	key string
+}
+
+func (k timerKey) String() string {
+	return k.typ.String() + ":" + k.key
+}
+
+// DataType 数据类型
+type DataType uint8
+
+const (
+	TypeKV        DataType = iota //k-v
+	TypeHash                      //hash
+	TypeSet                       //无序集合
+	TypeStream                    //流
+	TypeHLL                       //HyperLogLog
+	TypeGeo                       //地理位置
+	TypeBloom                     //布隆过滤器
+	TypeCuckoo                    //布谷鸟过滤器
+	TypeCMS                       //Count-Min Sketch
+	TypeTopK                      //Top-K
+	TypeLimit                     //限流
+	TypeLock                      //锁
+	TypeSemaphore                 //信号量
+	TypeRWLock                    //读写锁
+
+	dataTypeCount int = iota
+)
+
+func (t DataType) String() string {
+	switch t {
+	case TypeKV:
+		return "kv"
+	case TypeHash:
+		return "hash"
+	case TypeSet:
+		return "set"
+	case TypeStream:
+		return "stream"
+	case TypeHLL:
+		return "hyperloglog"
+	case TypeGeo:
+		return "geo"
+	case TypeBloom:
+		return "bloom"
+	case TypeCuckoo:
+		return "cuckoo"
+	case TypeCMS:
+		return "cms"
+	case TypeTopK:
+		return "topk"
+	case TypeLimit:
+		return "ratelimit"
+	case TypeLock:
+		return "lock"
+	case TypeSemaphore:
+		return "semaphore"
+	case TypeRWLock:
+		return "rwlock"
+	}
+	return "unknown"
 }
 
 type KVItem struct {
@@ -29,6 +143,7 @@ type KVItem struct {
 	Expiration int64       //过期时间
 	CallBack   bool        //是否回调
 	Key        string
+	Version    uint64 //版本号 每次写入都会变化
 }
 
 type HASHItem struct {
@@ -36,6 +151,7 @@ type HASHItem struct {
 	Expiration int64                  //过期时间
 	CallBack   bool                   //是否回调
 	Key        string
+	Version    uint64 //版本号 每次写入都会变化
 }
 
 type SetItem struct {
@@ -50,88 +166,157 @@ type Set struct {
 	CallBack     bool  //是否回调
 }
 
-func New() (*Cache, error) {
+func New(opts ...Option) (*Cache, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 	ip := GetLoaclIp()
 	node := Ipv4StringToInt(ip) % 256
 	sf, err := NewNode(node)
 	if err != nil {
 		return nil, err
 	}
-	tw := NewTw(time.Second, 60, nil)
+	tw := NewTw(time.Second, 60, nil, WithTwBuffer(o.timeWheelOps, o.timeWheelFired), WithTwOverflow(o.timeWheelOverflow), WithTwClock(o.clock))
 	tw.Start()
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	c := &Cache{&cache{
-		kvItems:        map[string]KVItem{},
-		hashItems:      map[string]HASHItem{},
-		setItems:       map[string]SetItem{},
-		deleteCallBack: nil,
-		snowflake:      sf,
-		timeWheel:      tw,
-		ctx:            ctx,
-		cancel:         cancelFunc,
+		kvItems:       map[string]KVItem{},
+		hashItems:     map[string]HASHItem{},
+		setItems:      map[string]SetItem{},
+		streamItems:   map[string]*streamItem{},
+		streamWaiters: map[string]*streamWaiter{},
+		hllItems:      map[string]*hllItem{},
+		geoItems:      map[string]*geoItem{},
+		bloomItems:    map[string]*bloomItem{},
+		cuckooItems:   map[string]*cuckooItem{},
+		cmsItems:      map[string]*cmsItem{},
+		topKItems:     map[string]*topKItem{},
+		limitItems:    map[string]*limitItem{},
+		lockItems:     map[string]*lockItem{},
+		semItems:      map[string]*leaseItem{},
+		rwLockItems:   map[string]*leaseItem{},
+		hotKeys:       newHotKeys(o.hotKeys),
+		snowflake:     sf,
+		timeWheel:     tw,
+		clock:         o.clock,
+		pubsub:        newPubSub(o),
+		closing:       make(chan struct{}),
+		runDone:       make(chan struct{}),
+		closeHooks:    o.closeHooks,
+		closeCallBack: o.callBacksOnClose,
+		ctx:           ctx,
+		cancel:        cancelFunc,
 	}}
+	c.dispatcher = newDispatcher(ctx, o, c.handleDeleteEvent)
 	go c.run()
 	return c, nil
 }
 
 func (c *cache) run() {
+	defer close(c.runDone)
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
+		case <-c.closing:
+			return
 		case data := <-c.timeWheel.C: //超时队列
-			switch v := data.(type) {
-			case KVItem:
-				c.kv_mu.Lock()
-				if i, b := c.kvDelete(v.Key); b {
-					if v.CallBack {
-						c.deleteCallBack(i.Key, i.Object)
-					}
-				}
-				c.kv_mu.Unlock()
-			case HASHItem:
-				c.hash_mu.Lock()
-				if i, b := c.hashDelete(v.Key); b {
-					if v.CallBack {
-						c.deleteCallBack(i.Key, i.Object)
-					}
-				}
-				c.hash_mu.Unlock()
-			case Set:
-				c.set_mu.Lock()
-				if i, b := c.setDelete(v.Key, v.Member); b {
-					if v.CallBack {
-						c.deleteCallBack(i.Key, i.Member)
-					}
-				}
-				c.set_mu.Unlock()
-			}
+			c.expire(data)
 		}
 	}
 }
 
-func (c *cache) BindDeleteCallBackFunc(f func(string, interface{})) {
-	c.kv_mu.Lock()
-	c.deleteCallBack = f
-	c.kv_mu.Unlock()
+// expire 处理时间轮到期的数据
+func (c *cache) expire(data interface{}) {
+	switch v := data.(type) {
+	case KVItem:
+		now := c.clock.Now().Unix()
+		c.kv_mu.Lock()
+		//定时器可能已经过时(值被覆盖、去掉了过期时间、或到期后才被覆盖)，只删除同一次写入的值或确实已经过期的值
+		i, ok := c.kvItems[v.Key]
+		ok = ok && (i.Version == v.Version || i.expired(now))
+		if ok {
+			delete(c.kvItems, v.Key)
+			c.markDeleted()
+		}
+		c.kv_mu.Unlock()
+		if ok {
+			c.stats.expire(TypeKV)
+			c.notify(EventExpire, TypeKV, v.Key)
+			if v.CallBack {
+				c.invokeCallBack(TypeKV, ReasonExpired, i.Key, kvObject(i.Object))
+			}
+		}
+	case HASHItem:
+		now := c.clock.Now().Unix()
+		c.hash_mu.Lock()
+		i, ok := c.hashItems[v.Key]
+		ok = ok && (i.Version == v.Version || i.expired(now))
+		if ok {
+			delete(c.hashItems, v.Key)
+			c.markDeleted()
+		}
+		c.hash_mu.Unlock()
+		if ok {
+			c.stats.expire(TypeHash)
+			c.notify(EventExpire, TypeHash, v.Key)
+			if v.CallBack {
+				c.invokeCallBack(TypeHash, ReasonExpired, i.Key, i.Object)
+			}
+		}
+	case Set:
+		c.set_mu.Lock()
+		i, ok := c.setItems[v.Key].Object[v.Member]
+		ok = ok && i.timeWheelKey == v.timeWheelKey //成员被重新添加后定时器已经换了
+		if ok {
+			c.setDelete(v.Key, v.Member)
+		}
+		c.set_mu.Unlock()
+		if ok {
+			c.stats.expire(TypeSet)
+			c.notify(EventExpire, TypeSet, v.Key)
+			if v.CallBack {
+				c.invokeCallBack(TypeSet, ReasonExpired, i.Key, i.Member)
+			}
+		}
+	case *hllItem:
+		c.hllExpire(v)
+	case *geoItem:
+		c.geoExpire(v)
+	case *limitItem:
+		c.limitExpire(v)
+	case *lockItem:
+		c.lockExpire(v)
+	case *leaseItem:
+		c.leaseExpire(v)
+	case *job:
+		v.q.push(v)
+	case *scheduleTick:
+		v.s.fire(v)
+	}
 }
 
+// Stop 立即停止 不等待到期处理和回调执行完，需要优雅关闭时使用Close
 func (c *cache) Stop() {
+	atomic.StoreInt32(&c.closed, 1)
 	c.cancel()
+	c.timeWheel.Stop()
+}
+
+// Timers 获取时间轮中全部定时器的快照 按预计触发时间排序
+// Key格式为"类型:key"，集合成员为成员的定时器ID，Data为到期时要删除的数据，用于排查数据删除后残留的定时器
+func (c *cache) Timers() []TimerInfo {
+	return c.timeWheel.Timers()
 }
 
 func (c *cache) Set(k string, v interface{}, d time.Duration, callBack bool) {
+	if c.isClosed() {
+		return
+	}
 	var endTime int64
 	if d > 0 {
-		endTime = time.Now().Add(d).Unix()
-	}
-	c.kv_mu.RLock()
-	val, ok := c.kvItems[k]
-	c.kv_mu.RUnlock()
-	if ok {
-		if val.Expiration > 0 {
-			c.timeWheel.RemoveTimer(k)
-		}
+		endTime = c.clock.Now().Add(d).Unix()
 	}
 	item := KVItem{
 		Object:     v,
@@ -140,91 +325,371 @@ func (c *cache) Set(k string, v interface{}, d time.Duration, callBack bool) {
 		Key:        k,
 	}
 	c.kv_mu.Lock()
-	c.kvItems[k] = item
+	after := c.kvStore(item, d)
 	c.kv_mu.Unlock()
-	c.timeWheel.AddTimer(d, k, item)
+	after()
+}
+
+// kvStore 写入k-v 调用方需持有kv_mu写锁，返回的函数需在释放锁之后执行，用于维护时间轮和统计
+func (c *cache) kvStore(item KVItem, d time.Duration) func() {
+	old, ok := c.kvItems[item.Key]
+	item.Version = c.nextVersion()
+	c.kvItems[item.Key] = item
+	return func() {
+		c.stats.set(TypeKV)
+		c.hotKeys.touch(TypeKV, item.Key)
+		if d > 0 {
+			c.timeWheel.AddTimer(d, timerKey{TypeKV, item.Key}, item) //替换原有的定时器
+		} else if ok && old.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeKV, item.Key})
+		}
+		c.notify(writeEvent(ok), TypeKV, item.Key)
+		if ok && old.CallBack {
+			c.invokeCallBack(TypeKV, ReasonOverwritten, old.Key, kvObject(old.Object))
+		}
+	}
 }
 
 func (c *cache) SetNx(k string, v interface{}, d time.Duration, callBack bool) bool {
+	if c.isClosed() {
+		return false
+	}
 	var endTime int64
 	if d > 0 {
-		endTime = time.Now().Add(d).Unix()
+		endTime = c.clock.Now().Add(d).Unix()
 	}
 	c.kv_mu.Lock()
-	defer c.kv_mu.Unlock()
-	_, ok := c.kvItems[k]
-	if ok {
+	if _, ok := c.kvItems[k]; ok {
+		c.kv_mu.Unlock()
 		return false
 	}
-	item := KVItem{
+	after := c.kvStore(KVItem{
 		Object:     v,
 		Expiration: endTime,
 		CallBack:   callBack,
 		Key:        k,
-	}
-	c.kvItems[k] = item
-	c.timeWheel.AddTimer(d, k, item)
+	}, d)
+	c.kv_mu.Unlock()
+	after()
 	return true
 }
 
 func (c *cache) Get(k string) (interface{}, bool) {
 	c.kv_mu.RLock()
 	item, ok := c.kvItems[k]
+	item.Object = kvCopy(item.Object)
 	c.kv_mu.RUnlock()
-	if !ok {
-		return nil, false
-	}
-	if item.Expiration <= time.Now().Unix() {
+	c.hotKeys.touch(TypeKV, k)
+	if !ok || item.expired(c.clock.Now().Unix()) {
+		c.stats.miss(TypeKV)
 		return nil, false
 	}
+	c.stats.hit(TypeKV)
 	return item.Object, true
 }
 
+// GetWithVersion 获取k-v值和版本号，用于CompareAndSwap
+func (c *cache) GetWithVersion(k string) (interface{}, uint64, bool) {
+	c.kv_mu.RLock()
+	item, ok := c.kvItems[k]
+	item.Object = kvCopy(item.Object)
+	c.kv_mu.RUnlock()
+	c.hotKeys.touch(TypeKV, k)
+	if !ok || item.expired(c.clock.Now().Unix()) {
+		c.stats.miss(TypeKV)
+		return nil, 0, false
+	}
+	c.stats.hit(TypeKV)
+	return item.Object, item.Version, true
+}
+
+// CompareAndSwap 版本号与当前一致时写入新值并返回true，保留原有过期时间和回调设置
+// version为0表示key必须不存在，此时写入的值永不过期
+func (c *cache) CompareAndSwap(k string, version uint64, v interface{}) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.kv_mu.Lock()
+	item, ok := c.kvItems[k]
+	if item.Version != version {
+		c.kv_mu.Unlock()
+		return false
+	}
+	if !ok {
+		item = KVItem{Key: k}
+	}
+	item.Object = v
+	item.Version = c.nextVersion()
+	c.kvItems[k] = item
+	c.kv_mu.Unlock()
+	c.stats.set(TypeKV)
+	c.notify(writeEvent(ok), TypeKV, k)
+	return true
+}
+
+// nextVersion 生成新的版本号
+func (c *cache) nextVersion() uint64 {
+	return atomic.AddUint64(&c.version, 1)
+}
+
+// markDeleted 记录删除k-v或hash时的版本号
+func (c *cache) markDeleted() {
+	atomic.StoreUint64(&c.delVersion, c.nextVersion())
+}
+
 // 获取k-v 过期时间
 func (c *cache) GetEx(k string) (interface{}, time.Time, bool) {
 	c.kv_mu.RLock()
 	item, ok := c.kvItems[k]
+	item.Object = kvCopy(item.Object)
 	c.kv_mu.RUnlock()
-	if !ok {
+	c.hotKeys.touch(TypeKV, k)
+	if !ok || item.expired(c.clock.Now().Unix()) {
+		c.stats.miss(TypeKV)
 		return nil, time.Time{}, false
 	}
-	if item.Expiration <= time.Now().Unix() {
-		return nil, time.Time{}, false
+	c.stats.hit(TypeKV)
+	if item.Expiration == 0 {
+		return item.Object, time.Time{}, true
 	}
 	return item.Object, time.Unix(item.Expiration, 0), true
 }
 
 // k-v删除
 func (c *cache) Del(k string) {
+	if c.isClosed() {
+		return
+	}
 	c.kv_mu.Lock()
+	after, ok := c.kvDel(k)
+	c.kv_mu.Unlock()
+	if ok {
+		after()
+	}
+}
+
+// kvDel 删除k-v 调用方需持有kv_mu写锁，返回的函数需在释放锁之后执行，用于移除定时器和触发回调
+func (c *cache) kvDel(k string) (func(), bool) {
 	v, ok := c.kvDelete(k)
+	if !ok {
+		return nil, false
+	}
+	return func() {
+		c.stats.delete(TypeKV)
+		if v.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeKV, k})
+		}
+		c.notify(EventDelete, TypeKV, k)
+		if v.CallBack {
+			c.invokeCallBack(TypeKV, ReasonDeleted, v.Key, kvObject(v.Object))
+		}
+	}, true
+}
+
+// IncrBy 将k-v的整数值加上n并返回结果，key不存在时从0开始且永不过期，保留原有过期时间
+func (c *cache) IncrBy(k string, n int64) (int64, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	c.kv_mu.Lock()
+	v, after, err := c.kvIncrBy(k, n)
 	c.kv_mu.Unlock()
-	if v.Expiration > 0 && ok {
-		c.timeWheel.RemoveTimer(k)
+	if err != nil {
+		return 0, err
+	}
+	after()
+	return v, nil
+}
+
+// kvIncrBy 调用方需持有kv_mu写锁，返回的函数需在释放锁之后执行
+func (c *cache) kvIncrBy(k string, n int64) (int64, func(), error) {
+	item, ok := c.kvItems[k]
+	if !ok {
+		item = KVItem{Key: k}
 	}
-	if ok && v.CallBack && c.deleteCallBack != nil {
-		c.deleteCallBack(v.Key, v.Object)
+	cur, err := toInt64(item.Object)
+	if err != nil {
+		return 0, nil, err
 	}
+	cur += n
+	item.Object = cur
+	item.Version = c.nextVersion()
+	c.kvItems[k] = item
+	return cur, func() {
+		c.stats.set(TypeKV)
+		c.notify(writeEvent(ok), TypeKV, k)
+	}, nil
+}
+
+// expired 是否已过期 Expiration为0表示永不过期
+func (item KVItem) expired(now int64) bool {
+	return item.Expiration > 0 && item.Expiration <= now
 }
 
 func (c *cache) kvDelete(k string) (KVItem, bool) {
 	if v, ok := c.kvItems[k]; ok {
 		delete(c.kvItems, k)
+		c.markDeleted()
 		return v, true
 	}
 	return KVItem{}, false
 }
 
+// Flush 清空所有数据，设置了回调的元素以ReasonFlushed触发回调
+func (c *cache) Flush() {
+	if c.isClosed() {
+		return
+	}
+	c.lockAll()
+	kvItems, hashItems, setItems := c.kvItems, c.hashItems, c.setItems
+	c.kvItems = map[string]KVItem{}
+	c.hashItems = map[string]HASHItem{}
+	c.setItems = map[string]SetItem{}
+	c.markDeleted()
+	c.unlockAll()
+
+	for k, item := range kvItems {
+		c.stats.delete(TypeKV)
+		if item.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeKV, k})
+		}
+		c.notify(EventDelete, TypeKV, k)
+		if item.CallBack {
+			c.invokeCallBack(TypeKV, ReasonFlushed, item.Key, kvObject(item.Object))
+		}
+	}
+	for key, item := range hashItems {
+		c.stats.delete(TypeHash)
+		if item.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeHash, key})
+		}
+		c.notify(EventDelete, TypeHash, key)
+		if item.CallBack {
+			c.invokeCallBack(TypeHash, ReasonFlushed, item.Key, item.Object)
+		}
+	}
+	for key, setItem := range setItems {
+		for _, item := range setItem.Object {
+			c.stats.delete(TypeSet)
+			if item.Expiration > 0 {
+				c.timeWheel.RemoveTimer(item.timeWheelKey)
+			}
+			if item.CallBack {
+				c.invokeCallBack(TypeSet, ReasonFlushed, item.Key, item.Member)
+			}
+		}
+		c.notify(EventDelete, TypeSet, key)
+	}
+
+	c.stream_mu.Lock()
+	streamItems := c.streamItems
+	c.streamItems = map[string]*streamItem{}
+	c.stream_mu.Unlock()
+	for key, st := range streamItems {
+		close(st.notify) //唤醒阻塞读取
+		c.stats.delete(TypeStream)
+		c.notify(EventDelete, TypeStream, key)
+	}
+
+	c.hll_mu.Lock()
+	hllItems := c.hllItems
+	c.hllItems = map[string]*hllItem{}
+	c.hll_mu.Unlock()
+	for key, h := range hllItems {
+		c.stats.delete(TypeHLL)
+		if h.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeHLL, key})
+		}
+		c.notify(EventDelete, TypeHLL, key)
+		if h.CallBack {
+			c.invokeCallBack(TypeHLL, ReasonFlushed, key, h.count())
+		}
+	}
+
+	c.geo_mu.Lock()
+	geoItems := c.geoItems
+	c.geoItems = map[string]*geoItem{}
+	c.geo_mu.Unlock()
+	for key, g := range geoItems {
+		c.stats.delete(TypeGeo)
+		if g.Expiration > 0 {
+			c.timeWheel.RemoveTimer(timerKey{TypeGeo, key})
+		}
+		c.notify(EventDelete, TypeGeo, key)
+		if g.CallBack {
+			c.invokeCallBack(TypeGeo, ReasonFlushed, key, g.locations())
+		}
+	}
+
+	c.bloom_mu.Lock()
+	bloomItems := c.bloomItems
+	c.bloomItems = map[string]*bloomItem{}
+	c.bloom_mu.Unlock()
+	for key := range bloomItems {
+		c.stats.delete(TypeBloom)
+		c.notify(EventDelete, TypeBloom, key)
+	}
+	c.cuckoo_mu.Lock()
+	cuckooItems := c.cuckooItems
+	c.cuckooItems = map[string]*cuckooItem{}
+	c.cuckoo_mu.Unlock()
+	for key := range cuckooItems {
+		c.stats.delete(TypeCuckoo)
+		c.notify(EventDelete, TypeCuckoo, key)
+	}
+	c.cms_mu.Lock()
+	cmsItems := c.cmsItems
+	c.cmsItems = map[string]*cmsItem{}
+	c.cms_mu.Unlock()
+	for key := range cmsItems {
+		c.stats.delete(TypeCMS)
+		c.notify(EventDelete, TypeCMS, key)
+	}
+	c.topk_mu.Lock()
+	topKItems := c.topKItems
+	c.topKItems = map[string]*topKItem{}
+	c.topk_mu.Unlock()
+	for key := range topKItems {
+		c.stats.delete(TypeTopK)
+		c.notify(EventDelete, TypeTopK, key)
+	}
+	c.limit_mu.Lock()
+	for range c.limitItems {
+		c.stats.delete(TypeLimit)
+	}
+	c.limitItems = map[string]*limitItem{}
+	c.limit_mu.Unlock()
+	c.lock_mu.Lock()
+	lockItems := c.lockItems
+	c.lockItems = map[string]*lockItem{}
+	c.lock_mu.Unlock()
+	for key, item := range lockItems {
+		close(item.released)
+		c.stats.delete(TypeLock)
+		c.notify(EventDelete, TypeLock, key)
+	}
+	c.lease_mu.Lock()
+	for _, items := range []map[string]*leaseItem{c.semItems, c.rwLockItems} {
+		for key, l := range items {
+			c.stats.delete(l.typ)
+			l.signal()
+			delete(items, key)
+		}
+	}
+	c.lease_mu.Unlock()
+}
+
 // 获取k-v所有值
 func (c *cache) Items() map[string]interface{} {
 	c.kv_mu.RLock()
 	defer c.kv_mu.RUnlock()
 	m := make(map[string]interface{}, len(c.kvItems))
-	now := time.Now().Unix()
+	now := c.clock.Now().Unix()
 	for k, v := range c.kvItems {
-		if now > v.Expiration {
+		if v.expired(now) {
 			continue
 		}
+		v.Object = kvCopy(v.Object)
 		m[k] = v
 	}
 	return m
@@ -243,136 +708,206 @@ func (c *cache) Exists(k string) bool {
 	c.kv_mu.RLock()
 	_, ok := c.kvItems[k]
 	c.kv_mu.RUnlock()
+	c.stats.lookup(TypeKV, ok)
+	c.hotKeys.touch(TypeKV, k)
 	return ok
 }
 
+func (item HASHItem) expired(now int64) bool {
+	return item.Expiration > 0 && item.Expiration <= now
+}
+
 func (c *cache) hashDelete(k string) (HASHItem, bool) {
 	if v, ok := c.hashItems[k]; ok {
 		delete(c.hashItems, k)
+		c.markDeleted()
 		return v, true
 	}
 	return HASHItem{}, false
 }
 
 func (c *cache) HSet(key, field string, val interface{}) {
-	c.hash_mu.RLock()
-	hash, ok := c.hashItems[key]
-	c.hash_mu.RUnlock()
-	if ok {
-		c.hash_mu.Lock()
-		hash.Object[field] = val
-		c.hash_mu.Unlock()
+	if c.isClosed() {
 		return
 	}
-	item := HASHItem{
-		Object: map[string]interface{}{field: val},
-		Key:    key,
-	}
 	c.hash_mu.Lock()
-	c.hashItems[key] = item
+	after := c.hashStore(key, map[string]interface{}{field: val})
 	c.hash_mu.Unlock()
+	after()
+}
+
+// hashStore 写入hash字段 调用方需持有hash_mu写锁，返回的函数需在释放锁之后执行
+func (c *cache) hashStore(key string, data map[string]interface{}) func() {
+	hash, ok := c.hashItems[key]
+	if !ok {
+		hash = HASHItem{
+			Object: make(map[string]interface{}, len(data)),
+			Key:    key,
+		}
+		c.hashItems[key] = hash
+	}
+	for field, value := range data {
+		hash.Object[field] = value
+	}
+	hash.Version = c.nextVersion()
+	c.hashItems[key] = hash
+	return func() {
+		c.stats.set(TypeHash)
+		c.hotKeys.touch(TypeHash, key)
+		c.notify(writeEvent(ok), TypeHash, key)
+	}
 }
 
 func (c *cache) HSetEx(key string, d time.Duration, callBack bool) bool {
+	if c.isClosed() {
+		return false
+	}
 	var endTime int64
 	if d > 0 {
-		endTime = time.Now().Add(d).Unix()
+		endTime = c.clock.Now().Add(d).Unix()
 	}
-	c.hash_mu.RLock()
+	c.hash_mu.Lock()
 	hash, ok := c.hashItems[key]
-	c.hash_mu.RUnlock()
-	if ok {
-		if hash.Expiration > 0 {
-			c.timeWheel.RemoveTimer(key)
-		}
-		c.hash_mu.Lock()
-		hash.CallBack = callBack
-		hash.Expiration = endTime
+	if !ok {
 		c.hash_mu.Unlock()
-		c.timeWheel.AddTimer(d, key, hash)
-		return true
+		return false
+	}
+	oldExpiration := hash.Expiration
+	hash.CallBack = callBack
+	hash.Expiration = endTime
+	hash.Version = c.nextVersion()
+	c.hashItems[key] = hash
+	c.hash_mu.Unlock()
+	if d > 0 {
+		c.timeWheel.AddTimer(d, timerKey{TypeHash, key}, hash) //替换原有的定时器
+	} else if oldExpiration > 0 {
+		c.timeWheel.RemoveTimer(timerKey{TypeHash, key})
 	}
-	return false
+	c.notify(EventUpdate, TypeHash, key)
+	return true
 }
 
 func (c *cache) HMSet(key string, data map[string]interface{}) {
-	if len(data) == 0 {
+	if c.isClosed() {
 		return
 	}
-	c.hash_mu.RLock()
-	hash, ok := c.hashItems[key]
-	c.hash_mu.RUnlock()
-	if ok {
-		c.hash_mu.Lock()
-		for field, value := range data {
-			hash.Object[field] = value
-		}
-		c.hash_mu.Unlock()
+	if len(data) == 0 {
 		return
 	}
-	item := HASHItem{
-		Key: key,
-	}
-	for field, value := range data {
-		item.Object[field] = value
-	}
 	c.hash_mu.Lock()
-	c.hashItems[key] = item
+	after := c.hashStore(key, data)
 	c.hash_mu.Unlock()
+	after()
 }
 
 func (c *cache) HSetNx(key, field string, val interface{}) bool {
-	c.hash_mu.RLock()
-	hash, ok := c.hashItems[key]
-	c.hash_mu.RUnlock()
-	if !ok {
-		item := HASHItem{
-			Object: map[string]interface{}{field: val},
-			Key:    key,
-		}
-		c.hash_mu.Lock()
-		c.hashItems[key] = item
-		c.hash_mu.Unlock()
-		return true
-	}
-	c.hash_mu.RLock()
-	_, ok = hash.Object[field]
-	c.hash_mu.RUnlock()
-	if ok {
+	if c.isClosed() {
 		return false
 	}
 	c.hash_mu.Lock()
-	hash.Object[field] = val
+	if hash, ok := c.hashItems[key]; ok {
+		if _, ok := hash.Object[field]; ok {
+			c.hash_mu.Unlock()
+			return false
+		}
+	}
+	after := c.hashStore(key, map[string]interface{}{field: val})
 	c.hash_mu.Unlock()
+	after()
 	return true
 }
 
 func (c *cache) HDel(key string, fields ...string) {
+	if c.isClosed() {
+		return
+	}
+	c.hash_mu.Lock()
+	after, ok := c.hashDel(key, fields...)
+	c.hash_mu.Unlock()
+	if ok {
+		after()
+	}
+}
+
+// hashDel 删除hash或hash字段 调用方需持有hash_mu写锁，返回的函数需在释放锁之后执行
+func (c *cache) hashDel(key string, fields ...string) (func(), bool) {
 	if len(fields) == 0 { //全部删除
-		c.hash_mu.Lock()
 		item, ok := c.hashDelete(key)
-		c.hash_mu.Unlock()
-		if item.Expiration > 0 && ok {
-			c.timeWheel.RemoveTimer(key)
-		}
-		if ok && item.CallBack && c.deleteCallBack != nil {
-			c.deleteCallBack(item.Key, item.Object)
+		if !ok {
+			return nil, false
 		}
-		return
+		return func() {
+			c.stats.delete(TypeHash)
+			if item.Expiration > 0 {
+				c.timeWheel.RemoveTimer(timerKey{TypeHash, key})
+			}
+			c.notify(EventDelete, TypeHash, key)
+			if item.CallBack {
+				c.invokeCallBack(TypeHash, ReasonDeleted, item.Key, item.Object)
+			}
+		}, true
 	}
-	c.hash_mu.Lock()
-	if hash, ok := c.hashItems[key]; ok {
-		for _, field := range fields {
+	hash, ok := c.hashItems[key]
+	if !ok {
+		return nil, false
+	}
+	removed := 0
+	for _, field := range fields {
+		if _, ok := hash.Object[field]; ok {
 			delete(hash.Object, field)
+			removed++
 		}
 	}
+	if removed == 0 { //字段都不存在
+		return nil, false
+	}
+	hash.Version = c.nextVersion()
+	c.hashItems[key] = hash
+	typ := EventUpdate //还有字段时视为修改
+	if len(hash.Object) == 0 {
+		typ = EventDelete
+	}
+	return func() {
+		c.stats.delete(TypeHash)
+		c.notify(typ, TypeHash, key)
+	}, true
+}
+
+// HIncrBy 将hash字段的整数值加上n并返回结果，字段不存在时从0开始
+func (c *cache) HIncrBy(key, field string, n int64) (int64, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	c.hash_mu.Lock()
+	v, after, err := c.hashIncrBy(key, field, n)
 	c.hash_mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	after()
+	return v, nil
+}
+
+// hashIncrBy 调用方需持有hash_mu写锁，返回的函数需在释放锁之后执行
+func (c *cache) hashIncrBy(key, field string, n int64) (int64, func(), error) {
+	var cur interface{}
+	if hash, ok := c.hashItems[key]; ok {
+		cur = hash.Object[field]
+	}
+	v, err := toInt64(cur)
+	if err != nil {
+		return 0, nil, err
+	}
+	v += n
+	return v, c.hashStore(key, map[string]interface{}{field: v}), nil
 }
 
 func (c *cache) HExists(key string, fields ...string) bool {
 	c.hash_mu.RLock()
 	hash, ok := c.hashItems[key]
 	c.hash_mu.RUnlock()
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
 	if !ok {
 		return false
 	}
@@ -389,6 +924,8 @@ func (c *cache) HGet(key string, fields ...string) map[string]interface{} {
 	c.hash_mu.RLock()
 	defer c.hash_mu.RUnlock()
 	hash, ok := c.hashItems[key]
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
 	res := make(map[string]interface{}, len(hash.Object))
 	if !ok {
 		return res
@@ -405,6 +942,8 @@ func (c *cache) HGetAll(key string) map[string]interface{} {
 	c.hash_mu.RLock()
 	defer c.hash_mu.RUnlock()
 	hash, ok := c.hashItems[key]
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
 	res := make(map[string]interface{}, len(hash.Object))
 	if !ok {
 		return res
@@ -415,10 +954,26 @@ func (c *cache) HGetAll(key string) map[string]interface{} {
 	return res
 }
 
+// HGetAllWithVersion 获取hash所有字段值和版本号
+func (c *cache) HGetAllWithVersion(key string) (map[string]interface{}, uint64, bool) {
+	c.hash_mu.RLock()
+	defer c.hash_mu.RUnlock()
+	hash, ok := c.hashItems[key]
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
+	res := make(map[string]interface{}, len(hash.Object))
+	for field, val := range hash.Object {
+		res[field] = val
+	}
+	return res, hash.Version, ok
+}
+
 func (c *cache) HKeys(key string) []string {
 	c.hash_mu.RLock()
 	defer c.hash_mu.RUnlock()
 	hash, ok := c.hashItems[key]
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
 	res := make([]string, 0, len(hash.Object))
 	if !ok {
 		return res
@@ -433,6 +988,8 @@ func (c *cache) HVAls(key string) []interface{} {
 	c.hash_mu.RLock()
 	defer c.hash_mu.RUnlock()
 	hash, ok := c.hashItems[key]
+	c.stats.lookup(TypeHash, ok)
+	c.hotKeys.touch(TypeHash, key)
 	res := make([]interface{}, 0, len(hash.Object))
 	if !ok {
 		return res
@@ -444,54 +1001,63 @@ func (c *cache) HVAls(key string) []interface{} {
 }
 
 func (c *cache) SAdd(key string, d time.Duration, callBack bool, members ...interface{}) {
+	if c.isClosed() {
+		return
+	}
 	if len(members) == 0 {
 		return
 	}
+	c.set_mu.Lock()
+	after := c.setAdd(key, d, callBack, members...)
+	c.set_mu.Unlock()
+	after()
+}
+
+// setAdd 添加集合成员 调用方需持有set_mu写锁，返回的函数需在释放锁之后执行，用于维护时间轮和统计
+func (c *cache) setAdd(key string, d time.Duration, callBack bool, members ...interface{}) func() {
 	var endTime int64
 	if d > 0 {
-		endTime = time.Now().Add(d).Unix()
+		endTime = c.clock.Now().Add(d).Unix()
 	}
-	c.set_mu.RLock()
 	setItem, ok := c.setItems[key]
-	c.set_mu.RUnlock()
-	if ok {
-		c.set_mu.Lock()
-		for _, member := range members {
-			if val, ok := setItem.Object[member]; ok {
-				if val.Expiration > 0 {
-					c.timeWheel.RemoveTimer(val.timeWheelKey)
-				}
-			}
-			timeWheelKey := c.snowflake.Generate().String()
-			item := Set{
-				Key:          key,
-				timeWheelKey: timeWheelKey,
-				Member:       member,
-				Expiration:   endTime,
-				CallBack:     callBack,
-			}
-			setItem.Object[member] = item
-			c.timeWheel.AddTimer(d, timeWheelKey, item)
-		}
-		c.set_mu.Unlock()
-		return
+	if !ok {
+		setItem = SetItem{Object: make(map[interface{}]Set, len(members))}
+		c.setItems[key] = setItem
 	}
-	c.set_mu.Lock()
-	object := map[interface{}]Set{}
+	var replaced []Set
+	added := make([]Set, 0, len(members))
 	for _, member := range members {
-		timeWheelKey := c.snowflake.Generate().String()
+		if val, ok := setItem.Object[member]; ok {
+			replaced = append(replaced, val)
+		}
 		item := Set{
 			Key:          key,
-			timeWheelKey: timeWheelKey,
+			timeWheelKey: c.snowflake.Generate().String(),
 			Member:       member,
 			Expiration:   endTime,
 			CallBack:     callBack,
 		}
-		object[member] = item
-		c.timeWheel.AddTimer(d, timeWheelKey, item)
+		setItem.Object[member] = item
+		added = append(added, item)
+	}
+	return func() {
+		c.stats.set(TypeSet)
+		c.hotKeys.touch(TypeSet, key)
+		for _, item := range replaced {
+			if item.Expiration > 0 {
+				c.timeWheel.RemoveTimer(item.timeWheelKey)
+			}
+		}
+		for _, item := range added {
+			c.timeWheel.AddTimer(d, item.timeWheelKey, item)
+		}
+		c.notify(writeEvent(ok), TypeSet, key)
+		for _, item := range replaced {
+			if item.CallBack {
+				c.invokeCallBack(TypeSet, ReasonOverwritten, item.Key, item.Member)
+			}
+		}
 	}
-	c.setItems[key] = SetItem{Object: object}
-	c.set_mu.Unlock()
 }
 
 func (c *cache) setDelete(key string, memberKey interface{}) (Set, bool) {
@@ -510,6 +1076,8 @@ func (c *cache) SCard(key string) int {
 	c.set_mu.RLock()
 	setItem, ok := c.setItems[key]
 	c.set_mu.RUnlock()
+	c.stats.lookup(TypeSet, ok)
+	c.hotKeys.touch(TypeSet, key)
 	if !ok {
 		return 0
 	}
@@ -517,29 +1085,49 @@ func (c *cache) SCard(key string) int {
 }
 
 func (c *cache) SRem(key string, members ...interface{}) int {
-	i := 0
+	if c.isClosed() {
+		return 0
+	}
 	if len(members) == 0 {
-		return i
+		return 0
 	}
+	c.set_mu.Lock()
+	i, after := c.setRem(key, members...)
+	c.set_mu.Unlock()
+	after()
+	return i
+}
+
+// setRem 删除集合成员 调用方需持有set_mu写锁，返回的函数需在释放锁之后执行
+func (c *cache) setRem(key string, members ...interface{}) (int, func()) {
+	removed := make([]Set, 0, len(members))
 	for _, member := range members {
-		c.set_mu.Lock()
-		item, ok := c.setDelete(key, member)
-		c.set_mu.Unlock()
-		if item.Expiration > 0 && ok {
-			c.timeWheel.RemoveTimer(item.timeWheelKey)
+		if item, ok := c.setDelete(key, member); ok {
+			removed = append(removed, item)
+		}
+	}
+	return len(removed), func() {
+		if len(removed) > 0 {
+			c.notify(EventDelete, TypeSet, key)
 		}
-		if ok && item.CallBack && c.deleteCallBack != nil {
-			c.deleteCallBack(item.Key, item.Member)
+		for _, item := range removed {
+			c.stats.delete(TypeSet)
+			if item.Expiration > 0 {
+				c.timeWheel.RemoveTimer(item.timeWheelKey)
+			}
+			if item.CallBack {
+				c.invokeCallBack(TypeSet, ReasonDeleted, item.Key, item.Member)
+			}
 		}
-		i++
 	}
-	return i
 }
 
 func (c *cache) SMembers(key string) []interface{} {
 	c.set_mu.RLock()
 	defer c.set_mu.RUnlock()
 	setItem, ok := c.setItems[key]
+	c.stats.lookup(TypeSet, ok)
+	c.hotKeys.touch(TypeSet, key)
 	members := make([]interface{}, 0, len(setItem.Object))
 	if !ok {
 		return members
@@ -554,11 +1142,10 @@ func (c *cache) SISMembers(key string, member interface{}) bool {
 	c.set_mu.RLock()
 	defer c.set_mu.RUnlock()
 	setItem, ok := c.setItems[key]
-	if !ok {
-		return false
-	}
-	if _, ok := setItem.Object[member]; ok {
-		return true
+	if ok {
+		_, ok = setItem.Object[member]
 	}
-	return false
+	c.stats.lookup(TypeSet, ok)
+	c.hotKeys.touch(TypeSet, key)
+	return ok
 }
@@ -1,50 +1,103 @@
 package speed
 
 import (
+	"errors"
 	"fmt"
+	"net/http/httptest"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func TestSpeedKV(t *testing.T) {
-	c, err := New()
+// newFakeCache 创建使用FakeClock的缓存 过期只随clock.Advance发生
+func newFakeCache(t *testing.T, opts ...Option) (*Cache, *FakeClock) {
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	c, err := New(append(opts, WithClock(clock))...)
 	if err != nil {
-		fmt.Println(err)
-		return
+		t.Fatal(err)
 	}
+	t.Cleanup(c.Stop)
+	return c, clock
+}
+
+// bindCallBacks 把删除回调以"key=value"的形式写入channel
+func bindCallBacks(c *Cache) <-chan string {
+	ch := make(chan string, 16)
+	c.BindDeleteCallBackFunc(func(k string, v interface{}) {
+		ch <- fmt.Sprint(k, "=", v)
+	})
+	return ch
+}
+
+// recvCallBacks 接收n个回调 排序后返回，回调在工作协程中执行，顺序不固定
+func recvCallBacks(t *testing.T, ch <-chan string, n int) []string {
+	t.Helper()
+	res := make([]string, 0, n)
+	for len(res) < n {
+		select {
+		case v := <-ch:
+			res = append(res, v)
+		case <-time.After(time.Second):
+			t.Fatalf("got callbacks %v, want %d", res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestSpeedKV(t *testing.T) {
+	c, clock := newFakeCache(t)
+	callBacks := bindCallBacks(c)
 	c.Set("key", 1, time.Second*5, false) //设置缓存 0为永不过期
-	fmt.Println(c.SetNx("key", 1, 0, false))
-	fmt.Println(c.Get("key"))   //获取结果 返回1 true
-	fmt.Println(c.GetEx("key")) //获取结果以及过期时间 1 2022-07-30 14:35:19 +0800 CST true
-	c.Del("key")                //删除缓存
-	fmt.Println(c.Get("key"))   //<nil> false
+	if c.SetNx("key", 2, 0, false) {
+		t.Fatal("SetNx overwrote an existing key")
+	}
+	if v, ok := c.Get("key"); !ok || v != 1 {
+		t.Fatalf("Get = %v, %v", v, ok)
+	}
+	if v, exp, ok := c.GetEx("key"); !ok || v != 1 || !exp.Equal(clock.Now().Add(time.Second*5)) {
+		t.Fatalf("GetEx = %v, %v, %v", v, exp, ok)
+	}
+	c.Del("key")
+	if _, ok := c.Get("key"); ok {
+		t.Fatal("key still exists after Del")
+	}
 
 	c.Set("key", 1, time.Second*5, false) //设置缓存 5秒后过期
-	time.Sleep(time.Second * 3)           //生命周期还剩两秒
+	clock.Advance(time.Second * 3)        //生命周期还剩两秒
 	c.Set("key", 1, time.Second*5, false) //再次设置相同的key，更新生命周期
-	time.Sleep(time.Second * 3)           //生命周期还剩五秒
-	fmt.Println(c.Get("key"))             //1 true
+	clock.Advance(time.Second * 3)        //生命周期还剩两秒
+	if v, ok := c.Get("key"); !ok || v != 1 {
+		t.Fatalf("Get after refresh = %v, %v", v, ok)
+	}
+	clock.Advance(time.Second * 3)
+	if _, ok := c.Get("key"); ok {
+		t.Fatal("key did not expire")
+	}
+	waitFor(t, func() bool { return c.ItemCount() == 0 })
 
-	//绑定回调函数，当主动删除缓存或者缓存过期触发  v就是设置的缓存值
-	c.BindDeleteCallBackFunc(func(k string, v interface{}) {
-		fmt.Println(v)
-		fmt.Println("触发回调函数")
-	})
 	c.Set("test01", 100, time.Second*3, false)
 	c.Del("test01")                           //callBack false 不触发回调函数
 	c.Set("test02", 200, time.Second*2, true) //两秒后过期触发回调函数
-	time.Sleep(time.Second * 10)              //阻塞
+	clock.Advance(time.Second * 3)
+	if got := recvCallBacks(t, callBacks, 1); got[0] != "test02=200" {
+		t.Fatalf("callbacks = %v", got)
+	}
+	select {
+	case v := <-callBacks:
+		t.Fatalf("unexpected callback %s", v)
+	default:
+	}
 }
 
 func TestSpeedHash(t *testing.T) {
-	c, err := New()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+	c, clock := newFakeCache(t)
+	expired := make(chan string, 1)
 	c.BindDeleteCallBackFunc(func(k string, v interface{}) {
-		fmt.Println("触发回调函数")
+		expired <- k
 	})
 	c.HSet("userinfo", "name", "城邦")
 	c.HSet("userinfo", "age", 30)
@@ -53,77 +106,105 @@ func TestSpeedHash(t *testing.T) {
 		"aaa": 111,
 		"bbb": 222,
 	})
-	fmt.Println("HSetNX", c.HSetNx("userinfo", "ccc", 30))
-	fmt.Println("HSetNX", c.HSetNx("userinfo", "age", 30))
-	fmt.Println(c.HExists("userinfo", "age"))
-	fmt.Println(c.HExists("userinfo1", "class"))
-	fmt.Println(c.HGet("userinfo", "age", "name"))
-	fmt.Println(c.HKeys("userinfo"))
-	fmt.Println(c.HVAls("userinfo"))
+	if !c.HSetNx("userinfo", "ccc", 30) || c.HSetNx("userinfo", "age", 31) {
+		t.Fatal("HSetNx ignored an existing field or rejected a new one")
+	}
+	if !c.HExists("userinfo", "age") || c.HExists("userinfo1", "class") || c.HExists("userinfo", "age", "class") {
+		t.Fatal("HExists returned a wrong result")
+	}
+	if got := c.HGet("userinfo", "age", "name", "class"); len(got) != 2 || got["age"] != 30 || got["name"] != "城邦" {
+		t.Fatalf("HGet = %v", got)
+	}
+	if len(c.HKeys("userinfo")) != 6 || len(c.HVAls("userinfo")) != 6 {
+		t.Fatal("HKeys/HVAls returned a wrong number of fields")
+	}
 	c.HDel("userinfo", "age", "sex")
-	fmt.Println("过期前:", c.HGetAll("userinfo"))
-	c.HSetEx("userinfo", time.Second*1, true)
-	time.Sleep(time.Second * 2)
-	fmt.Println("过期后:", c.HGetAll("userinfo"))
+	if got := c.HGetAll("userinfo"); len(got) != 4 || got["aaa"] != 111 {
+		t.Fatalf("HGetAll after HDel = %v", got)
+	}
+	if !c.HSetEx("userinfo", time.Second*1, true) {
+		t.Fatal("HSetEx on an existing hash failed")
+	}
+	clock.Advance(time.Second * 2)
+	select {
+	case k := <-expired:
+		if k != "userinfo" {
+			t.Fatalf("expired %s", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hash did not expire")
+	}
+	if len(c.HGetAll("userinfo")) != 0 {
+		t.Fatal("hash still exists after expiring")
+	}
 }
 
 func TestSpeedSet(t *testing.T) {
-	c, err := New()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	c.BindDeleteCallBackFunc(func(k string, v interface{}) {
-		fmt.Println(v)
-		fmt.Println("触发回调函数")
-	})
+	c, clock := newFakeCache(t)
+	callBacks := bindCallBacks(c)
 	c.SAdd("1070", time.Second*1, true, 1001, 1002, 1003)
-	c.SAdd("1070", time.Second*10, true, 1001)
-	time.Sleep(time.Second * 5)
-	fmt.Println(c.SMembers("1070"))
-	//fmt.Println(c.SISMembers("1070", 1001))
-	//fmt.Println(c.SISMembers("1070", 1005))
-	//fmt.Println(c.SCard("1070"))
-	fmt.Println(c.SMembers("1070"))
-	time.Sleep(time.Second * 4)
-	//fmt.Println(c.SISMembers("1070", 1001))
-	fmt.Println(c.SMembers("1070"))
+	c.SAdd("1070", time.Second*10, true, 1001) //重新设置1001的过期时间，覆盖不触发回调
+	clock.Advance(time.Second * 5)
+	if got := recvCallBacks(t, callBacks, 2); got[0] != "1070=1002" || got[1] != "1070=1003" {
+		t.Fatalf("callbacks = %v", got)
+	}
+	waitFor(t, func() bool { return c.SCard("1070") == 1 })
+	if !c.SISMembers("1070", 1001) || c.SISMembers("1070", 1002) {
+		t.Fatal("wrong members after the first expiry")
+	}
+	clock.Advance(time.Second * 4)
+	if got := c.SMembers("1070"); len(got) != 1 || got[0] != 1001 {
+		t.Fatalf("SMembers = %v", got)
+	}
+	clock.Advance(time.Second * 2)
+	if got := recvCallBacks(t, callBacks, 1); got[0] != "1070=1001" {
+		t.Fatalf("callbacks = %v", got)
+	}
+	waitFor(t, func() bool { return c.SCard("1070") == 0 })
+
 	c.SAdd("1070", time.Second*3, true, 1008)
-	fmt.Println(c.SMembers("1070"))
-	time.Sleep(time.Second * 10)
-	fmt.Println(c.SMembers("1070"))
+	if got := c.SMembers("1070"); len(got) != 1 || got[0] != 1008 {
+		t.Fatalf("SMembers = %v", got)
+	}
+	clock.Advance(time.Second * 4)
+	if got := recvCallBacks(t, callBacks, 1); got[0] != "1070=1008" {
+		t.Fatalf("callbacks = %v", got)
+	}
 }
 
 func TestSpeedSetRem(t *testing.T) {
-	cache, err := New()
-	if err != nil {
-		fmt.Println("实例化缓存出错", err)
-		return
-	}
-	cache.BindDeleteCallBackFunc(func(key string, val interface{}) {
-		fmt.Printf("回调函数 key:%s,val:%v\r\n", key, val)
-	})
+	cache, clock := newFakeCache(t)
+	callBacks := bindCallBacks(cache)
 	cache.Set("name", "城邦", time.Second*5, true)
-	if get, b := cache.Get("name"); b {
-		fmt.Println("val:", get)
+	if get, ok := cache.Get("name"); !ok || get != "城邦" {
+		t.Fatalf("Get = %v, %v", get, ok)
 	}
 	cache.SAdd("members", time.Second*10, false, 1001)
 	cache.SAdd("members", time.Second*11, true, 1002)
 	cache.SAdd("members", time.Second*12, true, 1003)
 	cache.SAdd("members", time.Second*13, true, 1004)
 	cache.SAdd("members", time.Second*14, true, 1005)
-	fmt.Println(cache.SMembers("members"))
-	fmt.Println(cache.SRem("members", 1001))
-	fmt.Println(cache.SRem("members", 1002))
-	fmt.Println(cache.SMembers("members"))
-	select {}
+	if n := len(cache.SMembers("members")); n != 5 {
+		t.Fatalf("SMembers returned %d members, want 5", n)
+	}
+	if cache.SRem("members", 1001) != 1 || cache.SRem("members", 1002) != 1 || cache.SRem("members", 1002) != 0 {
+		t.Fatal("SRem returned a wrong count")
+	}
+	if n := len(cache.SMembers("members")); n != 3 {
+		t.Fatalf("SMembers returned %d members after SRem, want 3", n)
+	}
+	clock.Advance(time.Second * 15) //删除的成员不会再过期，1001没有回调
+	want := []string{"members=1002", "members=1003", "members=1004", "members=1005", "name=城邦"}
+	if got := recvCallBacks(t, callBacks, len(want)); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("callbacks = %v, want %v", got, want)
+	}
+	waitFor(t, func() bool { return cache.SCard("members") == 0 && cache.ItemCount() == 0 })
 }
 
 func BenchmarkCache_SetEx(b *testing.B) {
 	c, err := New()
 	if err != nil {
-		fmt.Println(err)
-		return
+		b.Fatal(err)
 	}
 	for i := 0; i < b.N; i++ {
 		c.Set("key-"+strconv.Itoa(i), i, time.Second*60, false)
@@ -133,8 +214,7 @@ func BenchmarkCache_SetEx(b *testing.B) {
 func BenchmarkCache_SetExAndDel(b *testing.B) {
 	c, err := New()
 	if err != nil {
-		fmt.Println(err)
-		return
+		b.Fatal(err)
 	}
 	for i := 0; i < b.N; i++ {
 		c.Set("key-"+strconv.Itoa(i), i, time.Second*60, false)
@@ -146,8 +226,7 @@ func BenchmarkCache_SetExAndDel(b *testing.B) {
 func BenchmarkCache_Set(b *testing.B) {
 	c, err := New()
 	if err != nil {
-		fmt.Println(err)
-		return
+		b.Fatal(err)
 	}
 	for i := 0; i < b.N; i++ {
 		c.Set("key-"+strconv.Itoa(i), i, 0, false)
@@ -157,10 +236,265 @@ func BenchmarkCache_Set(b *testing.B) {
 func BenchmarkCacheSetRepeat(b *testing.B) {
 	c, err := New()
 	if err != nil {
-		fmt.Println(err)
-		return
+		b.Fatal(err)
 	}
 	for i := 0; i < b.N; i++ {
 		c.Set("key", i, time.Second*60, false)
 	}
 }
+
+func TestSpeedStats(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("key", 1, 0, false)
+	c.Get("key")
+	c.Get("missing")
+	c.Del("key")
+	c.HSet("userinfo", "name", "城邦")
+	c.HGetAll("userinfo")
+	kv := c.Stats()[TypeKV]
+	if kv.Hits != 1 || kv.Misses != 1 || kv.Sets != 1 || kv.Deletes != 1 {
+		t.Fatalf("unexpected kv stats %+v", kv)
+	}
+	if kv.HitRatio() != 0.5 {
+		t.Fatalf("hit ratio = %v", kv.HitRatio())
+	}
+	if total := c.Stats().Total(); total.Sets != 2 || total.Hits != 2 {
+		t.Fatalf("unexpected total stats %+v", total)
+	}
+	c.ResetStats()
+	if total := c.Stats().Total(); total != (TypeStats{}) {
+		t.Fatalf("stats not reset %+v", total)
+	}
+}
+
+func TestSpeedMetrics(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.BindDeleteCallBackFunc(func(k string, v interface{}) {})
+	c.Set("key", 1, time.Minute, true)
+	c.Get("key")
+	c.Del("key")
+	c.SAdd("members", time.Minute, false, 1001, 1002)
+	var body string
+	waitFor(t, func() bool {
+		rec := httptest.NewRecorder()
+		c.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body = rec.Body.String()
+		return strings.Contains(body, `speed_callback_duration_seconds_count{type="kv"} 1`)
+	})
+	for _, want := range []string{
+		`speed_items{store="set"} 1`,
+		`speed_set_members 2`,
+		`speed_hit_ratio{type="kv"} 1`,
+		`speed_callback_duration_seconds_count{type="kv"} 1`,
+		`speed_snowflake_ids_generated_total 2`,
+		`speed_timewheel_channel_capacity 10000`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSpeedDeleteHandler(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	events := make(chan DeleteEvent, 10)
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		events <- ev
+	})
+	c.Set("key", 1, 0, true)
+	c.Set("key", 2, 0, true)
+	c.Del("key")
+	c.SAdd("members", 0, true, 1001)
+	c.HSet("userinfo", "name", "城邦")
+	c.HSetEx("userinfo", time.Minute, true)
+	c.Flush()
+	want := []struct {
+		typ    DataType
+		reason DeleteReason
+		value  interface{}
+	}{
+		{TypeKV, ReasonOverwritten, 1},
+		{TypeKV, ReasonDeleted, 2},
+		{TypeHash, ReasonFlushed, nil},
+		{TypeSet, ReasonFlushed, 1001},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Type != w.typ || ev.Reason != w.reason || (w.value != nil && ev.Value != w.value) {
+				t.Fatalf("callback %d = %+v, want %+v", i, ev, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for callback %d", i)
+		}
+	}
+	if c.ItemCount() != 0 || c.SCard("members") != 0 || len(c.HGetAll("userinfo")) != 0 {
+		t.Fatal("Flush left entries behind")
+	}
+}
+
+func TestSpeedCallBackDispatcher(t *testing.T) {
+	errs := make(chan error, 1)
+	c, err := New(WithCallBackWorkers(4), WithOrderedCallBacks(), WithCallBackErrorHandler(func(err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	done := make(chan struct{})
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		if ev.Key == "panic" {
+			panic("boom")
+		}
+		c.Set("seen:"+ev.Key, ev.Value, 0, false) //回调中操作缓存不会死锁
+		close(done)
+	})
+	c.Set("panic", 1, 0, true)
+	c.Del("panic")
+	select {
+	case err := <-errs:
+		var pe *CallBackPanicError
+		if !errors.As(err, &pe) || pe.Event.Key != "panic" || pe.Value != "boom" {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic was not reported")
+	}
+	c.Set("key", 1, 0, true)
+	c.Del("key")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("callback was not delivered after a panic")
+	}
+	if v, ok := c.Get("seen:key"); !ok || v != 1 {
+		t.Fatalf("seen:key = %v, %v", v, ok)
+	}
+}
+
+// waitFor 轮询等待条件成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSpeedStaleExpire(t *testing.T) {
+	c, clock := newFakeCache(t)
+	callBacks := bindCallBacks(c)
+	c.Set("key", 1, time.Second*10, true)
+	c.kv_mu.RLock()
+	stale := c.kvItems["key"]
+	c.kv_mu.RUnlock()
+	c.Set("key", 2, 0, true) //覆盖为永不过期，旧定时器已经到期
+	c.expire(stale)
+	if v, ok := c.Get("key"); !ok || v != 2 {
+		t.Fatalf("stale timer deleted the new value: %v, %v", v, ok)
+	}
+
+	c.HSet("userinfo", "name", "城邦")
+	c.HSetEx("userinfo", time.Second*10, true)
+	c.hash_mu.RLock()
+	staleHash := c.hashItems["userinfo"]
+	c.hash_mu.RUnlock()
+	c.HSetEx("userinfo", 0, true)
+	c.expire(staleHash)
+	if len(c.HGetAll("userinfo")) != 1 {
+		t.Fatal("stale timer deleted the hash")
+	}
+
+	c.SAdd("members", time.Second*10, true, 1001)
+	c.set_mu.RLock()
+	staleMember := c.setItems["members"].Object[1001]
+	c.set_mu.RUnlock()
+	c.SAdd("members", 0, false, 1001)
+	c.expire(staleMember)
+	if !c.SISMembers("members", 1001) {
+		t.Fatal("stale timer deleted the re-added member")
+	}
+
+	c.Set("counter", 1, time.Second, false) //原地修改的值仍然按原来的过期时间删除
+	c.IncrBy("counter", 1)
+	clock.Advance(time.Second * 2)
+	waitFor(t, func() bool { return c.ItemCount() == 1 })
+	select {
+	case v := <-callBacks:
+		t.Fatalf("unexpected callback %s", v)
+	default:
+	}
+}
+
+func TestSpeedCallBackOverflow(t *testing.T) {
+	c, err := New(WithCallBackQueueSize(1), WithOrderedCallBacks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	keys := make(chan string, 10)
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		keys <- ev.Key
+		if ev.Key == "k0" { //队列只能放一个，回调中删除的key进入溢出队列，不会死锁
+			for i := 1; i <= 5; i++ {
+				c.Del("k" + strconv.Itoa(i))
+			}
+		}
+	})
+	for i := 0; i <= 5; i++ {
+		c.Set("k"+strconv.Itoa(i), i, 0, true)
+	}
+	c.Del("k0")
+	for i := 0; i <= 5; i++ {
+		select {
+		case k := <-keys:
+			if k != "k"+strconv.Itoa(i) {
+				t.Fatalf("callback %d for %s", i, k)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("callback %d was not delivered", i)
+		}
+	}
+}
+
+func TestSpeedCallBackDrop(t *testing.T) {
+	c, err := New(WithCallBackQueueSize(1), WithCallBackOverflow(DropOnOverflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		started <- struct{}{}
+		<-release
+	})
+	for i := 0; i < 3; i++ {
+		c.Set("k"+strconv.Itoa(i), i, 0, true)
+	}
+	c.Del("k0")
+	<-started //k0正在执行
+	c.Del("k1")
+	c.Del("k2") //队列已满，丢弃
+	if n := atomic.LoadUint64(&c.dispatcher.dropped); n != 1 {
+		t.Fatalf("dropped = %d, want 1", n)
+	}
+	close(release)
+	<-started
+}
@@ -0,0 +1,89 @@
+package speed
+
+import "time"
+
+// DeleteReason 元素被移除的原因
+type DeleteReason uint8
+
+const (
+	ReasonDeleted     DeleteReason = iota //主动删除 Del/HDel/SRem
+	ReasonExpired                         //时间轮到期
+	ReasonEvicted                         //被缓存淘汰 预留，目前没有淘汰策略
+	ReasonOverwritten                     //被新的值覆盖 Set/SAdd相同的key或成员
+	ReasonFlushed                         //Flush清空
+	ReasonClosed                          //Close时还未过期 需要开启WithCallBacksOnClose
+)
+
+func (r DeleteReason) String() string {
+	switch r {
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonExpired:
+		return "expired"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonOverwritten:
+		return "overwritten"
+	case ReasonFlushed:
+		return "flushed"
+	case ReasonClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// DeleteEvent 删除回调参数
+type DeleteEvent struct {
+	Key    string
+	Value  interface{} //k-v为存储的值，hash为map[string]interface{}，集合为成员
+	Type   DataType
+	Reason DeleteReason
+	Time   time.Time
+}
+
+// BindDeleteHandler 绑定删除回调，设置了callBack的元素被删除、过期、覆盖或清空时触发
+// 回调在工作协程中异步执行，可以在回调中操作缓存
+func (c *cache) BindDeleteHandler(f func(DeleteEvent)) {
+	c.deleteHandler.Store(f)
+}
+
+// BindDeleteCallBackFunc 绑定删除回调，只接收key和值，被覆盖时不触发
+func (c *cache) BindDeleteCallBackFunc(f func(string, interface{})) {
+	if f == nil {
+		c.BindDeleteHandler(nil)
+		return
+	}
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		if ev.Reason == ReasonOverwritten {
+			return
+		}
+		f(ev.Key, ev.Value)
+	})
+}
+
+func (c *cache) loadDeleteHandler() func(DeleteEvent) {
+	f, _ := c.deleteHandler.Load().(func(DeleteEvent))
+	return f
+}
+
+// invokeCallBack 将删除回调投递给分发器 不能在持有数据锁时调用
+func (c *cache) invokeCallBack(t DataType, reason DeleteReason, key string, val interface{}) {
+	if c.loadDeleteHandler() == nil {
+		return
+	}
+	c.dispatcher.dispatch(DeleteEvent{Key: key, Value: val, Type: t, Reason: reason, Time: c.clock.Now()})
+}
+
+// handleDeleteEvent 在分发器的工作协程中执行回调并记录次数和耗时
+func (c *cache) handleDeleteEvent(ev DeleteEvent) {
+	f := c.loadDeleteHandler()
+	if f == nil {
+		return
+	}
+	c.stats.callback(ev.Type)
+	start := time.Now()
+	defer func() {
+		c.callBackCost[ev.Type].observe(time.Since(start))
+	}()
+	f(ev)
+}
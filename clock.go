@@ -0,0 +1,189 @@
+package speed
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时间来源 缓存和时间轮通过它获取当前时间、创建ticker和定时器，测试时可替换为FakeClock
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker 周期性触发的ticker
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// Timer AfterFunc创建的定时器
+type Timer interface {
+	Stop() bool //定时器已经触发或已经停止时返回false
+}
+
+// realClock 系统时间
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// FakeClock 手动推进的时钟 只有调用Advance时时间才会前进
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	timers  []*fakeTimer
+}
+
+// NewFakeClock 创建从now开始的时钟
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now 当前时间
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker 创建只在Advance时触发的ticker
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("speed: non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		clock: c,
+		d:     d,
+		next:  c.now.Add(d),
+		ch:    make(chan time.Time),
+		done:  make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// AfterFunc 创建只在Advance时触发的定时器 f在调用Advance的协程中执行，不能阻塞，d不大于0时在下一次Advance时触发
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance 时间前进d 期间到期的tick和定时器按时间顺序逐个触发，每个tick被接收后才投递下一个
+// 时间轮收到下一个tick说明上一个tick已经处理完
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var next *fakeTicker
+		for _, t := range c.tickers {
+			if !t.next.After(target) && (next == nil || t.next.Before(next.next)) {
+				next = t
+			}
+		}
+		timer := -1
+		for i, t := range c.timers {
+			if !t.at.After(target) && (timer < 0 || t.at.Before(c.timers[timer].at)) {
+				timer = i
+			}
+		}
+		if timer >= 0 && (next == nil || c.timers[timer].at.Before(next.next)) {
+			t := c.timers[timer]
+			c.timers = append(c.timers[:timer], c.timers[timer+1:]...)
+			if t.at.After(c.now) {
+				c.now = t.at
+			}
+			c.mu.Unlock()
+			t.f()
+			continue
+		}
+		if next == nil {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		at := next.next
+		c.now = at
+		next.next = at.Add(next.d)
+		c.mu.Unlock()
+		select {
+		case next.ch <- at:
+		case <-next.done:
+		}
+	}
+}
+
+// fakeTicker FakeClock创建的ticker
+type fakeTicker struct {
+	clock *FakeClock
+	d     time.Duration
+	next  time.Time //下次触发时间 受clock.mu保护
+	ch    chan time.Time
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+		c := t.clock
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == t {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// fakeTimer FakeClock创建的定时器
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
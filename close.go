@@ -0,0 +1,129 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrClosed 缓存已经关闭
+var ErrClosed = errors.New("cache closed")
+
+// isClosed 是否已经调用Close或Stop
+func (c *cache) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Close 优雅关闭
+// 先停止接受写入和时间轮，处理已经到期的数据，开启WithCallBacksOnClose时对未到期的数据触发回调，
+// 等待回调执行完后依次执行WithCloseHook添加的钩子，最后停止任务队列和调度器
+// 之后返回error的写操作返回ErrClosed，其余写操作不报错、直接丢弃写入:
+// 没有返回值的Set、Del、Flush、HSet、HMSet、HDel、SAdd、CFAdd、PFMerge静默不生效；
+// 返回bool或数量的SetNx、CompareAndSwap、HSetEx、HSetNx、SRem、XAdd、XDel、XTrim、XGroupDestroy、BFAdd、BFMAdd、
+// CFAddNx、PFAdd、GeoRem、Publish以及各类Del、SetEx、ReleaseLock、ExtendLock、ResetLimit返回false、0或nil；
+// RateLimiter.AllowN返回RetryAfter为-1的拒绝结果
+// 只读操作(包括返回error的查询)仍然可以读取关闭时的数据，阻塞读取没有数据时返回ErrClosed，
+// 已经在等待的阻塞读取和锁、信号量等待也返回ErrClosed，XReadGroup等会修改消费状态的操作视为写操作
+// ctx结束时不再等待回调，返回ctx的错误；重复调用返回ErrClosed
+func (c *cache) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return ErrClosed
+	}
+	defer c.cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() { //ctx结束时关闭分发器，BlockOnOverflow下投递回调不再等待
+		select {
+		case <-ctx.Done():
+			c.dispatcher.close()
+		case <-done:
+		}
+	}()
+	fired, pending := c.timeWheel.Shutdown()
+	close(c.closing)
+	<-c.runDone
+	for len(c.timeWheel.C) > 0 && ctx.Err() == nil {
+		c.closeExpire(<-c.timeWheel.C)
+	}
+	for _, data := range fired {
+		if ctx.Err() != nil {
+			break
+		}
+		c.closeExpire(data)
+	}
+	if c.closeCallBack {
+		c.closeCallBacks(ctx, pending)
+	}
+	err := c.dispatcher.shutdown(ctx)
+	for _, hook := range c.closeHooks {
+		if hookErr := hook(ctx); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return err
+}
+
+// closeExpire 关闭时处理已经到期的数据 任务队列和调度器随缓存停止，不再投递延迟任务和调度
+func (c *cache) closeExpire(data interface{}) {
+	switch data.(type) {
+	case *job, *scheduleTick:
+		return
+	}
+	c.expire(data)
+}
+
+// closeCallBacks 对还在时间轮中等待过期的数据以ReasonClosed触发回调 只处理仍然存在且设置了回调的数据
+func (c *cache) closeCallBacks(ctx context.Context, pending []interface{}) {
+	for _, data := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		switch v := data.(type) {
+		case KVItem:
+			c.kv_mu.RLock()
+			item, ok := c.kvItems[v.Key]
+			c.kv_mu.RUnlock()
+			if ok && item.CallBack {
+				c.invokeCallBack(TypeKV, ReasonClosed, item.Key, kvObject(item.Object))
+			}
+		case HASHItem:
+			c.hash_mu.RLock()
+			item, ok := c.hashItems[v.Key]
+			c.hash_mu.RUnlock()
+			if ok && item.CallBack {
+				c.invokeCallBack(TypeHash, ReasonClosed, item.Key, item.Object)
+			}
+		case Set:
+			c.set_mu.RLock()
+			item, ok := c.setItems[v.Key].Object[v.Member]
+			c.set_mu.RUnlock()
+			if ok && item.CallBack && item.timeWheelKey == v.timeWheelKey {
+				c.invokeCallBack(TypeSet, ReasonClosed, item.Key, item.Member)
+			}
+		case *hllItem:
+			c.hll_mu.RLock()
+			h, ok := c.hllItems[v.Key]
+			ok = ok && h == v && h.CallBack
+			var count uint64
+			if ok {
+				count = h.count()
+			}
+			c.hll_mu.RUnlock()
+			if ok {
+				c.invokeCallBack(TypeHLL, ReasonClosed, h.Key, count)
+			}
+		case *geoItem:
+			c.geo_mu.RLock()
+			g, ok := c.geoItems[v.Key]
+			ok = ok && g == v && g.CallBack
+			var locations []GeoLocation
+			if ok {
+				locations = g.locations()
+			}
+			c.geo_mu.RUnlock()
+			if ok {
+				c.invokeCallBack(TypeGeo, ReasonClosed, g.Key, locations)
+			}
+		}
+	}
+}
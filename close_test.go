@@ -0,0 +1,164 @@
+package speed
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	events := make(chan DeleteEvent, 10)
+	var hooked int
+	c, clock := newFakeCache(t, WithCallBacksOnClose(), WithCloseHook(func(ctx context.Context) error {
+		hooked = len(events) //钩子在回调执行完之后执行
+		return nil
+	}))
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		events <- ev
+	})
+	c.Set("a", 1, time.Second, true)
+	c.Set("b", 2, time.Hour, true)
+	c.Set("c", 3, time.Hour, false)
+	c.SAdd("members", time.Hour, true, 1001)
+	clock.Advance(time.Second * 2)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if hooked != 3 {
+		t.Fatalf("hook saw %d callbacks, want 3", hooked)
+	}
+	close(events)
+	var got []string
+	for ev := range events {
+		got = append(got, ev.Key+":"+ev.Reason.String())
+	}
+	sort.Strings(got)
+	if len(got) != 3 || got[0] != "a:expired" || got[1] != "b:closed" || got[2] != "members:closed" {
+		t.Fatalf("callbacks = %v", got)
+	}
+
+	select {
+	case <-c.timeWheel.doneChannel:
+	default:
+		t.Fatal("time wheel goroutine is still running")
+	}
+	select {
+	case <-c.runDone:
+	default:
+		t.Fatal("run goroutine is still running")
+	}
+	c.Set("x", 1, 0, false)
+	if _, ok := c.Get("x"); ok {
+		t.Fatal("Set after Close stored a value")
+	}
+	if v, ok := c.Get("b"); !ok || v != 2 {
+		t.Fatal("data is not readable after Close")
+	}
+	if _, err := c.GetBit("x", 0); err != nil {
+		t.Fatalf("GetBit err = %v after Close", err)
+	}
+	if _, err := c.GeoRadius("x", 0, 0, 1, Meters, 0); err != nil {
+		t.Fatalf("GeoRadius err = %v after Close", err)
+	}
+	if _, err := c.XReadBlock(context.Background(), "x", 0, 0); err != ErrClosed {
+		t.Fatalf("XReadBlock err = %v, want ErrClosed", err)
+	}
+	if _, err := c.IncrBy("n", 1); err != ErrClosed {
+		t.Fatalf("IncrBy err = %v, want ErrClosed", err)
+	}
+	if _, err := c.NewLocker(time.Second).TryAcquire("lock"); err != ErrClosed {
+		t.Fatalf("TryAcquire err = %v, want ErrClosed", err)
+	}
+	if _, err := c.Multi().Set("x", 1, 0, false).Exec(); err != ErrClosed {
+		t.Fatalf("Exec err = %v, want ErrClosed", err)
+	}
+	if err := c.Close(context.Background()); err != ErrClosed {
+		t.Fatalf("second Close err = %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	c, _ := newFakeCache(t)
+	release := make(chan struct{})
+	defer close(release)
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		<-release
+	})
+	c.Set("key", 1, 0, true)
+	c.Del("key")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Close err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestCloseTimeoutBlockedDispatch(t *testing.T) {
+	c, clock := newFakeCache(t, WithCallBackQueueSize(1), WithCallBackOverflow(BlockOnOverflow))
+	release := make(chan struct{})
+	defer close(release)
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		<-release
+	})
+	for i := 0; i < 5; i++ {
+		c.Set("key"+strconv.Itoa(i), i, time.Second, true)
+	}
+	clock.Advance(time.Second * 2) //回调队列已满，过期处理阻塞在投递上
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Close(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Close err = %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close ignored the ctx deadline")
+	}
+}
+
+func TestCloseWakesWaiters(t *testing.T) {
+	c, _ := newFakeCache(t)
+	c.XAdd("jobs", 0, map[string]interface{}{"n": 0})
+	c.XGroupCreate("jobs", "workers", StreamLast)
+	locker := c.NewLocker(time.Hour)
+	locker.TryAcquire("lock")
+	sem, _ := c.NewSemaphore(1, time.Hour)
+	sem.TryAcquire("sem")
+	errs := make(chan error, 4)
+	go func() {
+		_, err := c.XReadBlock(context.Background(), "events", StreamMinID, 0)
+		errs <- err
+	}()
+	go func() {
+		_, err := c.XReadGroupBlock(context.Background(), "jobs", "workers", "alice", 0)
+		errs <- err
+	}()
+	go func() {
+		_, err := locker.Acquire(context.Background(), "lock")
+		errs <- err
+	}()
+	go func() {
+		_, err := sem.Acquire(context.Background(), "sem")
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond) //等待全部进入阻塞
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			if err != ErrClosed {
+				t.Fatalf("err = %v, want ErrClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("blocked waiter was not woken by Close")
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package speed
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrNoSketch     = errors.New("sketch does not exist")
+	ErrSketchExists = errors.New("sketch already exists")
+	ErrSketchParams = errors.New("invalid sketch parameters")
+	ErrSketchDims   = errors.New("sketches have different dimensions")
+)
+
+// SketchInfo Count-Min Sketch信息
+type SketchInfo struct {
+	Width uint32
+	Depth uint32
+	Count uint64 //所有元素计数之和
+}
+
+// cmsItem Count-Min Sketch depth行width列计数器，估算值只会偏大不会偏小
+type cmsItem struct {
+	width  uint32
+	depth  uint32
+	count  uint64
+	counts []uint64
+}
+
+func newCMSItem(width, depth uint32) *cmsItem {
+	return &cmsItem{width: width, depth: depth, counts: make([]uint64, uint64(width)*uint64(depth))}
+}
+
+// cmsDims 根据误差和置信度计算宽度和深度 误差为总计数的errorRate倍的概率不超过probability
+func cmsDims(errorRate, probability float64) (uint32, uint32) {
+	return uint32(math.Ceil(math.E / errorRate)), uint32(math.Ceil(math.Log(1 / probability)))
+}
+
+func (s *cmsItem) incr(item interface{}, n uint64) uint64 {
+	h1, h2 := bloomHash(item)
+	min := uint64(math.MaxUint64)
+	for i := uint64(0); i < uint64(s.depth); i++ {
+		idx := i*uint64(s.width) + (h1+i*h2)%uint64(s.width)
+		s.counts[idx] += n
+		if s.counts[idx] < min {
+			min = s.counts[idx]
+		}
+	}
+	s.count += n
+	return min
+}
+
+func (s *cmsItem) query(item interface{}) uint64 {
+	h1, h2 := bloomHash(item)
+	min := uint64(math.MaxUint64)
+	for i := uint64(0); i < uint64(s.depth); i++ {
+		if v := s.counts[i*uint64(s.width)+(h1+i*h2)%uint64(s.width)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *cmsItem) merge(o *cmsItem) {
+	for i, v := range o.counts {
+		s.counts[i] += v
+	}
+	s.count += o.count
+}
+
+// CMSInitByDim 按宽度和深度创建Count-Min Sketch
+func (c *cache) CMSInitByDim(key string, width, depth uint32) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if width == 0 || depth == 0 {
+		return ErrSketchParams
+	}
+	c.cms_mu.Lock()
+	if _, ok := c.cmsItems[key]; ok {
+		c.cms_mu.Unlock()
+		return ErrSketchExists
+	}
+	c.cmsItems[key] = newCMSItem(width, depth)
+	c.cms_mu.Unlock()
+	c.stats.set(TypeCMS)
+	c.notify(EventSet, TypeCMS, key)
+	return nil
+}
+
+// CMSInitByProb 按误差和误差超限概率创建Count-Min Sketch 两者都在(0, 1)之间
+func (c *cache) CMSInitByProb(key string, errorRate, probability float64) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if errorRate <= 0 || errorRate >= 1 || probability <= 0 || probability >= 1 {
+		return ErrSketchParams
+	}
+	width, depth := cmsDims(errorRate, probability)
+	return c.CMSInitByDim(key, width, depth)
+}
+
+// CMSIncrBy 增加元素计数 返回增加后的估算值
+func (c *cache) CMSIncrBy(key string, item interface{}, n uint64) (uint64, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	c.cms_mu.Lock()
+	s, ok := c.cmsItems[key]
+	if !ok {
+		c.cms_mu.Unlock()
+		return 0, ErrNoSketch
+	}
+	v := s.incr(item, n)
+	c.cms_mu.Unlock()
+	c.stats.set(TypeCMS)
+	c.notify(EventUpdate, TypeCMS, key)
+	return v, nil
+}
+
+// CMSQuery 查询元素计数的估算值
+func (c *cache) CMSQuery(key string, items ...interface{}) ([]uint64, error) {
+	c.cms_mu.RLock()
+	s, ok := c.cmsItems[key]
+	var res []uint64
+	if ok {
+		res = make([]uint64, len(items))
+		for i, item := range items {
+			res[i] = s.query(item)
+		}
+	}
+	c.cms_mu.RUnlock()
+	c.stats.lookup(TypeCMS, ok)
+	if !ok {
+		return nil, ErrNoSketch
+	}
+	return res, nil
+}
+
+// CMSMerge 将sources合并写入dest 所有sketch的宽度和深度必须一致，dest不存在时自动创建
+func (c *cache) CMSMerge(dest string, sources ...string) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.cms_mu.Lock()
+	var merged *cmsItem
+	for _, key := range sources {
+		s, ok := c.cmsItems[key]
+		if !ok {
+			c.cms_mu.Unlock()
+			return ErrNoSketch
+		}
+		if merged == nil {
+			merged = newCMSItem(s.width, s.depth)
+		} else if s.width != merged.width || s.depth != merged.depth {
+			c.cms_mu.Unlock()
+			return ErrSketchDims
+		}
+		merged.merge(s)
+	}
+	if merged == nil {
+		c.cms_mu.Unlock()
+		return ErrSketchParams
+	}
+	old, ok := c.cmsItems[dest]
+	if ok && (old.width != merged.width || old.depth != merged.depth) {
+		c.cms_mu.Unlock()
+		return ErrSketchDims
+	}
+	c.cmsItems[dest] = merged
+	c.cms_mu.Unlock()
+	c.stats.set(TypeCMS)
+	c.notify(writeEvent(ok), TypeCMS, dest)
+	return nil
+}
+
+// CMSInfo 获取Count-Min Sketch信息
+func (c *cache) CMSInfo(key string) (SketchInfo, bool) {
+	c.cms_mu.RLock()
+	defer c.cms_mu.RUnlock()
+	s, ok := c.cmsItems[key]
+	if !ok {
+		return SketchInfo{}, false
+	}
+	return SketchInfo{Width: s.width, Depth: s.depth, Count: s.count}, true
+}
+
+// CMSDel 删除Count-Min Sketch
+func (c *cache) CMSDel(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.cms_mu.Lock()
+	_, ok := c.cmsItems[key]
+	if ok {
+		delete(c.cmsItems, key)
+	}
+	c.cms_mu.Unlock()
+	if ok {
+		c.stats.delete(TypeCMS)
+		c.notify(EventDelete, TypeCMS, key)
+	}
+	return ok
+}
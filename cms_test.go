@@ -0,0 +1,54 @@
+package speed
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.CMSInitByProb("a", 0.001, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := c.CMSInfo("a"); info.Width != 2719 || info.Depth != 5 {
+		t.Fatalf("unexpected dims %+v", info)
+	}
+	if err := c.CMSInitByDim("a", 10, 2); err != ErrSketchExists {
+		t.Fatalf("err = %v, want ErrSketchExists", err)
+	}
+	if _, err := c.CMSIncrBy("missing", "x", 1); err != ErrNoSketch {
+		t.Fatalf("err = %v, want ErrNoSketch", err)
+	}
+	c.CMSInitByDim("b", 2719, 5)
+	for i := 0; i < 1000; i++ {
+		c.CMSIncrBy("a", "item-"+strconv.Itoa(i%100), 1)
+		c.CMSIncrBy("b", "item-"+strconv.Itoa(i%10), 2)
+	}
+	if v, _ := c.CMSIncrBy("a", "hot", 500); v < 500 {
+		t.Fatalf("CMSIncrBy = %d", v)
+	}
+	res, _ := c.CMSQuery("a", "item-1", "hot", "never")
+	if res[0] < 10 || res[0] > 12 || res[1] < 500 || res[1] > 502 || res[2] > 2 {
+		t.Fatalf("CMSQuery = %v", res)
+	}
+	if err := c.CMSMerge("ab", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := c.CMSQuery("ab", "item-1"); res[0] < 210 || res[0] > 213 {
+		t.Fatalf("merged count = %v", res)
+	}
+	if info, _ := c.CMSInfo("ab"); info.Count != 1000+500+2000 {
+		t.Fatalf("merged total = %d", info.Count)
+	}
+	c.CMSInitByDim("small", 10, 2)
+	if err := c.CMSMerge("ab", "a", "small"); err != ErrSketchDims {
+		t.Fatalf("err = %v, want ErrSketchDims", err)
+	}
+	if !c.CMSDel("ab") {
+		t.Fatal("CMSDel failed")
+	}
+}
@@ -3,6 +3,8 @@ package speed
 import (
 	"math/big"
 	"net"
+	"strconv"
+	"time"
 )
 
 func Ipv4StringToInt(ip string) int64 {
@@ -28,3 +30,143 @@ func GetLoaclIp() string {
 	}
 	return ip
 }
+
+// toInt64 将整数类型的值转换为int64 nil视为0
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		return i, nil
+	}
+	return 0, ErrNotInteger
+}
+
+// matchPattern glob风格匹配 支持*、?、[abc]、[^a]、[a-z]以及\转义
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			if end < len(pattern) && pattern[end] == '^' {
+				end++
+			}
+			if end < len(pattern) && pattern[end] == ']' {
+				end++
+			}
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' && end+1 < len(pattern) {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) { //没有闭合的[按普通字符处理
+				if s[0] != '[' {
+					return false
+				}
+				s = s[1:]
+				pattern = pattern[1:]
+				continue
+			}
+			if !matchClass(pattern[1:end], s[0]) {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass 匹配[]中的字符集合
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		lo := class[i]
+		if lo == '\\' && i+1 < len(class) {
+			i++
+			lo = class[i]
+		}
+		hi := lo
+		if i+2 < len(class) && class[i+1] == '-' {
+			hi = class[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// leaseTimerDelay 租约类定时器的延迟 时间轮精度为秒，到期时租约被延长会按剩余时间重新加入时间轮
+func leaseTimerDelay(d time.Duration) time.Duration {
+	if d < time.Second {
+		return time.Second
+	}
+	return d
+}
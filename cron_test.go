@@ -0,0 +1,41 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2024, 1, 31, 23, 59, 58, 500, time.UTC) //星期三
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * * *", time.Date(2024, 1, 31, 23, 59, 59, 0, time.UTC)},
+		{"*/15 * * * * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 10 * * * *", time.Date(2024, 2, 1, 0, 10, 30, 0, time.UTC)},
+		{"0 0 9 * * MON-FRI", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 12 29 FEB ?", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 0 13 * FRI", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)}, //日和星期都有限制时满足其一
+		{"0 1,13 * * *", time.Date(2024, 2, 1, 1, 0, 0, 0, time.UTC)},   //五个字段
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", base.Add(90 * time.Second)},
+	} {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.spec, err)
+		}
+		if got := s.Next(base); !got.Equal(tc.want) {
+			t.Errorf("%q: Next = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * * *", "* * * * * 8", "5-1 * * * * *", "*/0 * * * * *", "@every -1s", "@every x"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+	if s, _ := ParseCron("0 0 0 30 2 *"); !s.Next(base).IsZero() {
+		t.Error("impossible schedule should return zero time")
+	}
+}
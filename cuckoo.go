@@ -0,0 +1,394 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+)
+
+const (
+	cuckooBucketSize         = 4    //每个桶的指纹数
+	cuckooMaxKicks           = 500  //插入时最多踢出次数
+	DefaultCuckooCapacity    = 1024 //布谷鸟过滤器默认容量
+	cuckooMagic              = "SPCF"
+	cuckooMinFingerprintBits = 4
+	cuckooMaxFingerprintBits = 32
+)
+
+// cuckooLayer 单层布谷鸟过滤器 指纹为0表示空位
+type cuckooLayer struct {
+	capacity  uint64
+	count     uint64
+	errorRate float64
+	fpBits    uint32
+	buckets   uint64 //桶数量 2的幂
+	slots     []uint32
+}
+
+func newCuckooLayer(errorRate float64, capacity uint64) *cuckooLayer {
+	buckets := uint64(1)
+	for buckets*cuckooBucketSize < capacity {
+		buckets <<= 1
+	}
+	//误判率约为 2*桶大小/2^指纹位数
+	fpBits := uint32(math.Ceil(math.Log2(2 * cuckooBucketSize / errorRate)))
+	if fpBits < cuckooMinFingerprintBits {
+		fpBits = cuckooMinFingerprintBits
+	} else if fpBits > cuckooMaxFingerprintBits {
+		fpBits = cuckooMaxFingerprintBits
+	}
+	return &cuckooLayer{
+		capacity:  capacity,
+		errorRate: errorRate,
+		fpBits:    fpBits,
+		buckets:   buckets,
+		slots:     make([]uint32, buckets*cuckooBucketSize),
+	}
+}
+
+// locate 计算指纹和两个候选桶
+func (l *cuckooLayer) locate(h uint64) (uint32, uint64, uint64) {
+	fp := uint32(h>>32) & uint32(uint64(1)<<l.fpBits-1)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := h & (l.buckets - 1)
+	return fp, i1, l.alt(i1, fp)
+}
+
+// alt 另一个候选桶 alt(alt(i)) == i
+func (l *cuckooLayer) alt(i uint64, fp uint32) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (l.buckets - 1)
+}
+
+func (l *cuckooLayer) bucket(i uint64) []uint32 {
+	return l.slots[i*cuckooBucketSize : (i+1)*cuckooBucketSize]
+}
+
+func (l *cuckooLayer) put(i uint64, fp uint32) bool {
+	b := l.bucket(i)
+	for j := range b {
+		if b[j] == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (l *cuckooLayer) matches(h uint64) int {
+	fp, i1, i2 := l.locate(h)
+	n := 0
+	for _, v := range l.bucket(i1) {
+		if v == fp {
+			n++
+		}
+	}
+	if i2 != i1 {
+		for _, v := range l.bucket(i2) {
+			if v == fp {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// insert 插入指纹 两个桶都满时随机踢出已有指纹，失败则撤销所有踢出
+func (l *cuckooLayer) insert(h uint64) bool {
+	if l.count >= l.capacity {
+		return false
+	}
+	fp, i1, i2 := l.locate(h)
+	if l.put(i1, fp) || l.put(i2, fp) {
+		l.count++
+		return true
+	}
+	type kick struct {
+		idx uint64
+		old uint32
+	}
+	kicks := make([]kick, 0, cuckooMaxKicks)
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for n := 0; n < cuckooMaxKicks; n++ {
+		idx := i*cuckooBucketSize + uint64(rand.Intn(cuckooBucketSize))
+		kicks = append(kicks, kick{idx, l.slots[idx]})
+		fp, l.slots[idx] = l.slots[idx], fp
+		i = l.alt(i, fp)
+		if l.put(i, fp) {
+			l.count++
+			return true
+		}
+	}
+	for n := len(kicks) - 1; n >= 0; n-- {
+		l.slots[kicks[n].idx] = kicks[n].old
+	}
+	return false
+}
+
+func (l *cuckooLayer) remove(h uint64) bool {
+	fp, i1, i2 := l.locate(h)
+	for _, i := range []uint64{i1, i2} {
+		b := l.bucket(i)
+		for j := range b {
+			if b[j] == fp {
+				b[j] = 0
+				l.count--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cuckooItem 可扩容布谷鸟过滤器 支持删除
+type cuckooItem struct {
+	layers []*cuckooLayer
+}
+
+func newCuckooItem(errorRate float64, capacity uint64) *cuckooItem {
+	return &cuckooItem{layers: []*cuckooLayer{newCuckooLayer(errorRate, capacity)}}
+}
+
+func (f *cuckooItem) add(item interface{}) {
+	h := hash64(item)
+	last := f.layers[len(f.layers)-1]
+	if !last.insert(h) {
+		last = newCuckooLayer(last.errorRate*filterTightening, last.capacity*filterExpansion)
+		f.layers = append(f.layers, last)
+		last.insert(h)
+	}
+}
+
+func (f *cuckooItem) count(item interface{}) int {
+	h := hash64(item)
+	n := 0
+	for _, l := range f.layers {
+		n += l.matches(h)
+	}
+	return n
+}
+
+// remove 从最新的层开始删除一个指纹
+func (f *cuckooItem) remove(item interface{}) bool {
+	h := hash64(item)
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		if f.layers[i].remove(h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *cuckooItem) info() FilterInfo {
+	info := FilterInfo{Layers: len(f.layers), ErrorRate: f.layers[0].errorRate}
+	for _, l := range f.layers {
+		info.Capacity += l.capacity
+		info.Items += l.count
+		info.Bytes += len(l.slots) * 4
+	}
+	return info
+}
+
+func (f *cuckooItem) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(cuckooMagic)
+	buf.WriteByte(filterVersion)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(f.layers)))
+	for _, l := range f.layers {
+		binary.Write(&buf, binary.LittleEndian, []uint64{l.capacity, l.count, math.Float64bits(l.errorRate), uint64(l.fpBits), l.buckets})
+		binary.Write(&buf, binary.LittleEndian, l.slots)
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *cuckooItem) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if !readHeader(r, cuckooMagic) {
+		return ErrFilterData
+	}
+	var n uint32
+	if binary.Read(r, binary.LittleEndian, &n) != nil || n == 0 {
+		return ErrFilterData
+	}
+	layers := make([]*cuckooLayer, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var h [5]uint64
+		if binary.Read(r, binary.LittleEndian, &h) != nil {
+			return ErrFilterData
+		}
+		if h[3] < cuckooMinFingerprintBits || h[3] > cuckooMaxFingerprintBits {
+			return ErrFilterData
+		}
+		l := &cuckooLayer{capacity: h[0], count: h[1], errorRate: math.Float64frombits(h[2]), fpBits: uint32(h[3]), buckets: h[4]}
+		if !validFilterParams(l.errorRate, l.capacity) || l.count > l.capacity {
+			return ErrFilterData
+		}
+		//先用剩余长度限制桶数量，避免buckets*cuckooBucketSize*4溢出
+		if l.buckets == 0 || l.buckets&(l.buckets-1) != 0 || l.buckets > uint64(r.Len())/(cuckooBucketSize*4) {
+			return ErrFilterData
+		}
+		l.slots = make([]uint32, l.buckets*cuckooBucketSize)
+		if binary.Read(r, binary.LittleEndian, l.slots) != nil {
+			return ErrFilterData
+		}
+		layers = append(layers, l)
+	}
+	if r.Len() != 0 {
+		return ErrFilterData
+	}
+	f.layers = layers
+	return nil
+}
+
+// CFReserve 创建布谷鸟过滤器 errorRate为误判率 capacity为预计元素数量，超出容量后自动扩容
+func (c *cache) CFReserve(key string, errorRate float64, capacity uint64) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if !validFilterParams(errorRate, capacity) {
+		return ErrFilterParams
+	}
+	c.cuckoo_mu.Lock()
+	if _, ok := c.cuckooItems[key]; ok {
+		c.cuckoo_mu.Unlock()
+		return ErrFilterExists
+	}
+	c.cuckooItems[key] = newCuckooItem(errorRate, capacity)
+	c.cuckoo_mu.Unlock()
+	c.stats.set(TypeCuckoo)
+	c.notify(EventSet, TypeCuckoo, key)
+	return nil
+}
+
+// CFAdd 添加元素 允许重复添加 key不存在时按默认参数创建
+func (c *cache) CFAdd(key string, item interface{}) {
+	if c.isClosed() {
+		return
+	}
+	c.cfAdd(key, item, false)
+}
+
+// CFAddNx 元素不存在时添加 返回是否添加
+func (c *cache) CFAddNx(key string, item interface{}) bool {
+	if c.isClosed() {
+		return false
+	}
+	return c.cfAdd(key, item, true)
+}
+
+func (c *cache) cfAdd(key string, item interface{}, nx bool) bool {
+	c.cuckoo_mu.Lock()
+	f, ok := c.cuckooItems[key]
+	if !ok {
+		f = newCuckooItem(DefaultFilterErrorRate, DefaultCuckooCapacity)
+		c.cuckooItems[key] = f
+	}
+	added := !nx || f.count(item) == 0
+	if added {
+		f.add(item)
+	}
+	c.cuckoo_mu.Unlock()
+	c.stats.set(TypeCuckoo)
+	if added {
+		c.notify(writeEvent(ok), TypeCuckoo, key)
+	}
+	return added
+}
+
+// CFExists 判断元素是否可能存在 返回false时一定不存在
+func (c *cache) CFExists(key string, item interface{}) bool {
+	return c.CFCount(key, item) > 0
+}
+
+// CFCount 元素可能被添加的次数
+func (c *cache) CFCount(key string, item interface{}) int {
+	c.cuckoo_mu.RLock()
+	f, ok := c.cuckooItems[key]
+	n := 0
+	if ok {
+		n = f.count(item)
+	}
+	c.cuckoo_mu.RUnlock()
+	c.stats.lookup(TypeCuckoo, ok)
+	return n
+}
+
+// CFDel 删除一个元素 只能删除确实添加过的元素，否则可能误删其他元素
+func (c *cache) CFDel(key string, item interface{}) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.cuckoo_mu.Lock()
+	f, ok := c.cuckooItems[key]
+	ok = ok && f.remove(item)
+	c.cuckoo_mu.Unlock()
+	if ok {
+		c.stats.delete(TypeCuckoo)
+		c.notify(EventUpdate, TypeCuckoo, key)
+	}
+	return ok
+}
+
+// CFInfo 获取布谷鸟过滤器信息
+func (c *cache) CFInfo(key string) (FilterInfo, bool) {
+	c.cuckoo_mu.RLock()
+	defer c.cuckoo_mu.RUnlock()
+	f, ok := c.cuckooItems[key]
+	if !ok {
+		return FilterInfo{}, false
+	}
+	return f.info(), true
+}
+
+// CFDump 序列化布谷鸟过滤器 用于快照保存
+func (c *cache) CFDump(key string) ([]byte, bool) {
+	c.cuckoo_mu.RLock()
+	defer c.cuckoo_mu.RUnlock()
+	f, ok := c.cuckooItems[key]
+	if !ok {
+		return nil, false
+	}
+	data, _ := f.MarshalBinary()
+	return data, true
+}
+
+// CFRestore 从CFDump的结果恢复布谷鸟过滤器 覆盖已存在的key
+func (c *cache) CFRestore(key string, data []byte) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	f := &cuckooItem{}
+	if err := f.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	c.cuckoo_mu.Lock()
+	_, ok := c.cuckooItems[key]
+	c.cuckooItems[key] = f
+	c.cuckoo_mu.Unlock()
+	c.stats.set(TypeCuckoo)
+	c.notify(writeEvent(ok), TypeCuckoo, key)
+	return nil
+}
+
+// CFDrop 删除整个布谷鸟过滤器
+func (c *cache) CFDrop(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.cuckoo_mu.Lock()
+	_, ok := c.cuckooItems[key]
+	if ok {
+		delete(c.cuckooItems, key)
+	}
+	c.cuckoo_mu.Unlock()
+	if ok {
+		c.stats.delete(TypeCuckoo)
+		c.notify(EventDelete, TypeCuckoo, key)
+	}
+	return ok
+}
@@ -0,0 +1,114 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.CFReserve("ids", 0.001, 1000); err != nil {
+		t.Fatal(err)
+	}
+	const n = 5000 //超过容量，触发扩容
+	for i := 0; i < n; i++ {
+		c.CFAdd("ids", "id-"+strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ {
+		if !c.CFExists("ids", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("false negative for id-%d", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if c.CFExists("ids", "id-"+strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.005 {
+		t.Fatalf("false positive rate %.4f", rate)
+	}
+	if info, ok := c.CFInfo("ids"); !ok || info.Items != n || info.Layers < 2 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	for i := 0; i < n; i += 2 {
+		if !c.CFDel("ids", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("CFDel(id-%d) failed", i)
+		}
+	}
+	for i := 1; i < n; i += 2 {
+		if !c.CFExists("ids", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("delete removed id-%d", i)
+		}
+	}
+	if info, _ := c.CFInfo("ids"); info.Items != n/2 {
+		t.Fatalf("items after delete = %d", info.Items)
+	}
+
+	c.CFAdd("dup", "x")
+	c.CFAdd("dup", "x")
+	if c.CFCount("dup", "x") != 2 || c.CFAddNx("dup", "x") {
+		t.Fatal("duplicate adds not counted")
+	}
+	if !c.CFAddNx("dup", "y") {
+		t.Fatal("CFAddNx of a new item failed")
+	}
+
+	data, ok := c.CFDump("ids")
+	if !ok {
+		t.Fatal("CFDump failed")
+	}
+	if err := c.CFRestore("copy", data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < n; i += 98 {
+		if !c.CFExists("copy", "id-"+strconv.Itoa(i)) {
+			t.Fatalf("restored filter lost id-%d", i)
+		}
+	}
+	if err := c.CFRestore("copy", []byte("SPBF")); err != ErrFilterData {
+		t.Fatalf("err = %v, want ErrFilterData", err)
+	}
+	if !c.CFDrop("copy") || c.CFExists("copy", "id-1") {
+		t.Fatal("CFDrop failed")
+	}
+}
+
+func TestCuckooRestoreInvalid(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	layer := func(capacity, count uint64, errorRate float64, fpBits, buckets uint64, slots int) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(cuckooMagic)
+		buf.WriteByte(filterVersion)
+		binary.Write(&buf, binary.LittleEndian, uint32(1))
+		binary.Write(&buf, binary.LittleEndian, []uint64{capacity, count, math.Float64bits(errorRate), fpBits, buckets})
+		binary.Write(&buf, binary.LittleEndian, make([]uint32, slots))
+		return buf.Bytes()
+	}
+	if err := c.CFRestore("ok", layer(16, 0, 0.01, 10, 4, 16)); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]byte{
+		"buckets overflow": layer(16, 0, 0.01, 10, 1<<62, 0),
+		"zero capacity":    layer(0, 0, 0.01, 10, 4, 16),
+		"error rate":       layer(16, 0, 0, 10, 4, 16),
+		"count":            layer(16, 17, 0.01, 10, 4, 16),
+		"fingerprint bits": layer(16, 0, 0.01, 1<<32+10, 4, 16),
+	}
+	for name, data := range cases {
+		if err := c.CFRestore(name, data); err != ErrFilterData {
+			t.Fatalf("%s: err = %v, want ErrFilterData", name, err)
+		}
+	}
+}
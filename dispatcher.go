@@ -0,0 +1,192 @@
+package speed
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// CallBackPanicError 回调函数panic时传给错误处理函数
+type CallBackPanicError struct {
+	Event DeleteEvent //触发panic的回调参数
+	Value interface{} //recover得到的值
+	Stack []byte
+}
+
+func (e *CallBackPanicError) Error() string {
+	return fmt.Sprintf("delete callback panic on %s key %q: %v", e.Event.Type, e.Event.Key, e.Value)
+}
+
+// dispatcher 回调分发器 在工作协程中执行回调，不占用缓存的锁
+// 无序模式下所有工作协程共享一个队列，有序模式下按key哈希到固定的队列
+type dispatcher struct {
+	ctx     context.Context
+	queues  []*callBackQueue
+	ordered bool
+	policy  OverflowPolicy //队列满时的处理方式
+	dropped uint64         //DropOnOverflow时丢弃的回调数
+	handle  func(DeleteEvent)
+	onError func(error)
+	wg      sync.WaitGroup
+	stop    chan struct{} //关闭后工作协程执行完队列中的回调再退出
+	once    sync.Once
+}
+
+// callBackQueue 回调队列 SpillOnOverflow时channel满了放入spill，spill不为空时后续回调也放入spill，保证顺序
+type callBackQueue struct {
+	ch     chan DeleteEvent
+	mu     sync.Mutex
+	spill  []DeleteEvent
+	signal chan struct{}
+}
+
+func newDispatcher(ctx context.Context, o options, handle func(DeleteEvent)) *dispatcher {
+	d := &dispatcher{
+		ctx:     ctx,
+		ordered: o.orderedCallBacks,
+		policy:  o.callBackOverflow,
+		handle:  handle,
+		onError: o.callBackErrorHandler,
+		stop:    make(chan struct{}),
+	}
+	queueNum := 1
+	if d.ordered {
+		queueNum = o.callBackWorkers
+	}
+	d.queues = make([]*callBackQueue, queueNum)
+	for i := range d.queues {
+		d.queues[i] = &callBackQueue{
+			ch:     make(chan DeleteEvent, o.callBackQueueSize),
+			signal: make(chan struct{}, 1),
+		}
+	}
+	for i := 0; i < o.callBackWorkers; i++ {
+		d.wg.Add(1)
+		go d.work(d.queues[i%queueNum])
+	}
+	return d
+}
+
+// dispatch 投递回调 队列满时按policy等待、丢弃或放入溢出队列，缓存停止或分发器关闭后丢弃
+// 默认SpillOnOverflow不会阻塞，回调中再操作缓存不会因为自己的队列已满而死锁
+func (d *dispatcher) dispatch(ev DeleteEvent) {
+	q := d.queues[0]
+	if d.ordered && len(d.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(ev.Key))
+		q = d.queues[h.Sum32()%uint32(len(d.queues))]
+	}
+	switch d.policy {
+	case DropOnOverflow:
+		select {
+		case q.ch <- ev:
+		default:
+			atomic.AddUint64(&d.dropped, 1)
+		}
+	case BlockOnOverflow:
+		select {
+		case q.ch <- ev:
+		case <-d.ctx.Done():
+		case <-d.stop:
+		}
+	default:
+		q.mu.Lock()
+		if len(q.spill) == 0 {
+			select {
+			case q.ch <- ev:
+				q.mu.Unlock()
+				return
+			default:
+			}
+		}
+		q.spill = append(q.spill, ev)
+		q.mu.Unlock()
+		select {
+		case q.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *dispatcher) work(q *callBackQueue) {
+	defer d.wg.Done()
+	for {
+		select {
+		case ev := <-q.ch:
+			d.call(ev)
+		case <-q.signal:
+			d.drainSpill(q)
+		case <-d.ctx.Done():
+			return
+		case <-d.stop:
+			for d.drainSpill(q) {
+			}
+			return
+		}
+	}
+}
+
+// drainSpill 执行取出溢出队列时已在channel中的回调，再执行溢出队列中更晚的回调 返回是否执行了回调
+func (d *dispatcher) drainSpill(q *callBackQueue) bool {
+	q.mu.Lock()
+	n := len(q.ch)
+	spill := q.spill
+	q.spill = nil
+	q.mu.Unlock()
+	for i := 0; i < n; i++ {
+		select {
+		case ev := <-q.ch:
+			d.call(ev)
+		default: //共享队列的其他工作协程已经取走
+		}
+	}
+	for _, ev := range spill {
+		d.call(ev)
+	}
+	return n > 0 || len(spill) > 0
+}
+
+// backlog 等待执行的回调数
+func (d *dispatcher) backlog() int {
+	var n int
+	for _, q := range d.queues {
+		q.mu.Lock()
+		n += len(q.ch) + len(q.spill)
+		q.mu.Unlock()
+	}
+	return n
+}
+
+// close 停止接受新的回调 工作协程执行完队列中的回调后退出
+func (d *dispatcher) close() {
+	d.once.Do(func() { close(d.stop) })
+}
+
+// shutdown 等待队列中的回调执行完、工作协程退出 ctx结束时不再等待
+func (d *dispatcher) shutdown(ctx context.Context) error {
+	d.close()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// call 执行回调 panic时转为CallBackPanicError交给错误处理函数，不影响其他回调
+func (d *dispatcher) call(ev DeleteEvent) {
+	defer func() {
+		if r := recover(); r != nil && d.onError != nil {
+			d.onError(&CallBackPanicError{Event: ev, Value: r, Stack: debug.Stack()})
+		}
+	}()
+	d.handle(ev)
+}
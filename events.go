@@ -0,0 +1,165 @@
+package speed
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType 键空间事件类型
+type EventType uint8
+
+const (
+	EventSet    EventType = iota //新建
+	EventUpdate                  //修改已存在的key
+	EventDelete                  //主动删除
+	EventExpire                  //过期删除
+	EventEvict                   //被缓存淘汰 预留，目前没有淘汰策略
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event 键空间事件
+type Event struct {
+	Type     EventType
+	DataType DataType
+	Key      string
+	Time     time.Time
+}
+
+// DeliveryPolicy 订阅者缓冲区满时的处理方式
+type DeliveryPolicy uint8
+
+const (
+	DropOnFull  DeliveryPolicy = iota //丢弃事件并计数
+	BlockOnFull                       //阻塞直到订阅者读取
+)
+
+// EventSubscription 键空间事件订阅
+type EventSubscription struct {
+	C        <-chan Event //事件通道 Close之后关闭
+	ch       chan Event
+	done     chan struct{}
+	patterns []string
+	policy   DeliveryPolicy
+	dropped  uint64
+	once     sync.Once
+	bus      *eventBus
+}
+
+// eventBus 键空间事件订阅者列表
+type eventBus struct {
+	mu    sync.RWMutex
+	subs  []*EventSubscription
+	count int32 //订阅者数量 没有订阅者时跳过事件构造
+}
+
+// SubscribeEvents 订阅键空间事件 patterns为key的通配符(*、?、[abc])，为空时订阅全部key
+// bufSize为事件通道缓冲大小，policy决定缓冲区满时丢弃还是阻塞
+func (c *cache) SubscribeEvents(bufSize int, policy DeliveryPolicy, patterns ...string) *EventSubscription {
+	if bufSize < 0 {
+		bufSize = 0
+	}
+	ch := make(chan Event, bufSize)
+	sub := &EventSubscription{
+		C:        ch,
+		ch:       ch,
+		done:     make(chan struct{}),
+		patterns: patterns,
+		policy:   policy,
+		bus:      &c.events,
+	}
+	c.events.mu.Lock()
+	c.events.subs = append(c.events.subs, sub)
+	atomic.StoreInt32(&c.events.count, int32(len(c.events.subs)))
+	c.events.mu.Unlock()
+	return sub
+}
+
+// Dropped 因缓冲区满被丢弃的事件数量
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close 取消订阅并关闭事件通道
+func (s *EventSubscription) Close() {
+	s.once.Do(func() {
+		close(s.done) //先唤醒阻塞在发送上的协程，再获取写锁
+		b := s.bus
+		b.mu.Lock()
+		for i, sub := range b.subs {
+			if sub == s {
+				b.subs = append(b.subs[:i], b.subs[i+1:]...)
+				break
+			}
+		}
+		atomic.StoreInt32(&b.count, int32(len(b.subs)))
+		b.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+func (s *EventSubscription) match(key string) bool {
+	if len(s.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range s.patterns {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *EventSubscription) deliver(ev Event) {
+	if s.policy == BlockOnFull {
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.done:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// notify 发布键空间事件 不能在持有数据锁时调用，阻塞的订阅者可能回调缓存
+func (c *cache) notify(t EventType, dt DataType, key string) {
+	if atomic.LoadInt32(&c.events.count) == 0 {
+		return
+	}
+	ev := Event{Type: t, DataType: dt, Key: key, Time: c.clock.Now()}
+	c.events.mu.RLock()
+	defer c.events.mu.RUnlock()
+	for _, sub := range c.events.subs {
+		if sub.match(key) {
+			sub.deliver(ev)
+		}
+	}
+}
+
+// writeEvent 根据写入前key是否存在返回新建或修改事件
+func writeEvent(existed bool) EventType {
+	if existed {
+		return EventUpdate
+	}
+	return EventSet
+}
@@ -0,0 +1,107 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	sub := c.SubscribeEvents(16, DropOnFull, "user:*")
+	c.Set("user:1", 1, 0, false)
+	c.Set("user:1", 2, 0, false)
+	c.Set("order:1", 1, 0, false)
+	c.HSet("user:2", "name", "城邦")
+	c.Del("user:1")
+	want := []Event{
+		{Type: EventSet, DataType: TypeKV, Key: "user:1"},
+		{Type: EventUpdate, DataType: TypeKV, Key: "user:1"},
+		{Type: EventSet, DataType: TypeHash, Key: "user:2"},
+		{Type: EventDelete, DataType: TypeKV, Key: "user:1"},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-sub.C:
+			if ev.Type != w.Type || ev.DataType != w.DataType || ev.Key != w.Key {
+				t.Fatalf("event = %+v, want %+v", ev, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("channel not closed after Close")
+	}
+}
+
+func TestHDelEvents(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.HMSet("user:1", map[string]interface{}{"name": "城邦", "age": 18})
+	sub := c.SubscribeEvents(16, DropOnFull)
+	defer sub.Close()
+	c.HDel("user:1", "missing")
+	c.HDel("user:1", "age")
+	c.HDel("user:1", "name")
+	want := []EventType{EventUpdate, EventDelete}
+	for _, w := range want {
+		select {
+		case ev := <-sub.C:
+			if ev.Type != w || ev.Key != "user:1" {
+				t.Fatalf("event = %+v, want %v", ev, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v", w)
+		}
+	}
+	if n := c.Stats()[TypeHash].Deletes; n != 2 {
+		t.Fatalf("deletes = %d, want 2", n)
+	}
+}
+
+func TestSubscribeEventsDrop(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	sub := c.SubscribeEvents(1, DropOnFull)
+	defer sub.Close()
+	c.Set("a", 1, 0, false)
+	c.Set("b", 1, 0, false)
+	if sub.Dropped() != 1 {
+		t.Fatalf("dropped = %d, want 1", sub.Dropped())
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"*:1:*", "user:1:name", true},
+	}
+	for _, tc := range cases {
+		if got := matchPattern(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package speed
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	geoStep         = 26 //每个维度的精度位数 与redis一致
+	geoLatMin       = -85.05112878
+	geoLatMax       = 85.05112878
+	geoLonMin       = -180.0
+	geoLonMax       = 180.0
+	geoEarthRadius  = 6372797.560856 //地球半径 单位米
+	geoMercatorMax  = 20037726.37
+	geoDegToRadians = math.Pi / 180
+)
+
+// GeoUnit 距离单位 值为对应的米数
+type GeoUnit float64
+
+const (
+	Meters     GeoUnit = 1
+	Kilometers GeoUnit = 1000
+	Miles      GeoUnit = 1609.34
+	Feet       GeoUnit = 0.3048
+)
+
+// ErrInvalidCoordinates 经纬度超出范围
+var ErrInvalidCoordinates = errors.New("invalid longitude,latitude pair")
+
+// GeoLocation 地理位置
+type GeoLocation struct {
+	Name      string
+	Longitude float64
+	Latitude  float64
+	Dist      float64 //查询结果中到查询中心的距离，单位为查询时指定的单位
+}
+
+// GeoQuery 地理位置查询 Member不为空时以该成员为中心，否则以Longitude、Latitude为中心
+// Radius大于0时按圆形查询，否则按Width*Height的矩形查询
+type GeoQuery struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Radius    float64
+	Width     float64
+	Height    float64
+	Unit      GeoUnit //默认为Meters
+	Count     int     //大于0时最多返回Count个
+	Desc      bool    //按距离从远到近排序
+}
+
+// geoItem 地理位置集合 成员按geohash排序，便于按区域查找
+type geoItem struct {
+	Key        string
+	members    map[string]uint64 //成员 -> geohash
+	sorted     []geoMember       //按geohash、成员名排序
+	Expiration int64             //过期时间
+	CallBack   bool              //是否回调
+}
+
+type geoMember struct {
+	hash uint64
+	name string
+}
+
+func (g *geoItem) less(i int, m geoMember) bool {
+	return g.sorted[i].hash > m.hash || (g.sorted[i].hash == m.hash && g.sorted[i].name >= m.name)
+}
+
+func (g *geoItem) insert(name string, hash uint64) bool {
+	old, exists := g.members[name]
+	if exists {
+		if old == hash {
+			return false
+		}
+		g.remove(name)
+	}
+	m := geoMember{hash: hash, name: name}
+	i := sort.Search(len(g.sorted), func(i int) bool { return g.less(i, m) })
+	g.sorted = append(g.sorted, geoMember{})
+	copy(g.sorted[i+1:], g.sorted[i:])
+	g.sorted[i] = m
+	g.members[name] = hash
+	return !exists
+}
+
+func (g *geoItem) remove(name string) bool {
+	hash, ok := g.members[name]
+	if !ok {
+		return false
+	}
+	m := geoMember{hash: hash, name: name}
+	i := sort.Search(len(g.sorted), func(i int) bool { return g.less(i, m) })
+	g.sorted = append(g.sorted[:i], g.sorted[i+1:]...)
+	delete(g.members, name)
+	return true
+}
+
+func (g *geoItem) locations() []GeoLocation {
+	res := make([]GeoLocation, 0, len(g.sorted))
+	for _, m := range g.sorted {
+		lon, lat := geoDecode(m.hash)
+		res = append(res, GeoLocation{Name: m.name, Longitude: lon, Latitude: lat})
+	}
+	return res
+}
+
+func (g *geoItem) expired(now int64) bool {
+	return g.Expiration > 0 && g.Expiration <= now
+}
+
+// GeoAdd 添加或更新成员位置 返回新增的成员数量
+func (c *cache) GeoAdd(key string, locations ...GeoLocation) (int, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	for _, loc := range locations {
+		if !geoValid(loc.Longitude, loc.Latitude) {
+			return 0, ErrInvalidCoordinates
+		}
+	}
+	c.geo_mu.Lock()
+	g, ok := c.geoItems[key]
+	if !ok {
+		g = &geoItem{Key: key, members: map[string]uint64{}}
+		c.geoItems[key] = g
+	}
+	n := 0
+	for _, loc := range locations {
+		if g.insert(loc.Name, geoEncode(loc.Longitude, loc.Latitude)) {
+			n++
+		}
+	}
+	c.geo_mu.Unlock()
+	c.stats.set(TypeGeo)
+	c.notify(writeEvent(ok), TypeGeo, key)
+	return n, nil
+}
+
+// GeoPos 获取成员位置 位置为geohash格子的中心，与写入的值有微小误差
+func (c *cache) GeoPos(key, member string) (float64, float64, bool) {
+	c.geo_mu.RLock()
+	defer c.geo_mu.RUnlock()
+	hash, ok := c.geoMember(key, member)
+	if !ok {
+		return 0, 0, false
+	}
+	lon, lat := geoDecode(hash)
+	return lon, lat, true
+}
+
+// GeoDist 计算两个成员之间的距离
+func (c *cache) GeoDist(key, member1, member2 string, unit GeoUnit) (float64, bool) {
+	c.geo_mu.RLock()
+	defer c.geo_mu.RUnlock()
+	h1, ok1 := c.geoMember(key, member1)
+	h2, ok2 := c.geoMember(key, member2)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	lon1, lat1 := geoDecode(h1)
+	lon2, lat2 := geoDecode(h2)
+	return geoDistance(lon1, lat1, lon2, lat2) / float64(geoUnit(unit)), true
+}
+
+// GeoRadius 查找中心点radius范围内的成员 按距离从近到远排序
+func (c *cache) GeoRadius(key string, longitude, latitude, radius float64, unit GeoUnit, count int) ([]GeoLocation, error) {
+	return c.GeoSearch(key, GeoQuery{Longitude: longitude, Latitude: latitude, Radius: radius, Unit: unit, Count: count})
+}
+
+// GeoRadiusByMember 查找成员radius范围内的成员 按距离从近到远排序
+func (c *cache) GeoRadiusByMember(key, member string, radius float64, unit GeoUnit, count int) ([]GeoLocation, error) {
+	return c.GeoSearch(key, GeoQuery{Member: member, Radius: radius, Unit: unit, Count: count})
+}
+
+// GeoSearch 按圆形或矩形区域查找成员 结果按距离排序
+func (c *cache) GeoSearch(key string, q GeoQuery) ([]GeoLocation, error) {
+	unit := float64(geoUnit(q.Unit))
+	c.geo_mu.RLock()
+	defer c.geo_mu.RUnlock()
+	g, ok := c.geoItems[key]
+	ok = ok && !g.expired(c.clock.Now().Unix())
+	c.stats.lookup(TypeGeo, ok)
+	if !ok {
+		return []GeoLocation{}, nil
+	}
+	lon, lat := q.Longitude, q.Latitude
+	if q.Member != "" {
+		hash, exists := g.members[q.Member]
+		if !exists {
+			return []GeoLocation{}, nil
+		}
+		lon, lat = geoDecode(hash)
+	} else if !geoValid(lon, lat) {
+		return nil, ErrInvalidCoordinates
+	}
+	radius := q.Radius * unit
+	width, height := q.Width*unit, q.Height*unit
+	searchRadius := radius
+	if radius <= 0 {
+		searchRadius = math.Sqrt(width*width+height*height) / 2
+	}
+
+	res := make([]GeoLocation, 0)
+	for _, r := range geoRanges(lon, lat, searchRadius) {
+		i := sort.Search(len(g.sorted), func(i int) bool { return g.sorted[i].hash >= r[0] })
+		for ; i < len(g.sorted) && g.sorted[i].hash < r[1]; i++ {
+			m := g.sorted[i]
+			mLon, mLat := geoDecode(m.hash)
+			dist := geoDistance(lon, lat, mLon, mLat)
+			if radius > 0 {
+				if dist > radius {
+					continue
+				}
+			} else if !geoInBox(lon, lat, mLon, mLat, width, height) {
+				continue
+			}
+			res = append(res, GeoLocation{Name: m.name, Longitude: mLon, Latitude: mLat, Dist: dist / unit})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Dist == res[j].Dist {
+			return res[i].Name < res[j].Name
+		}
+		if q.Desc {
+			return res[i].Dist > res[j].Dist
+		}
+		return res[i].Dist < res[j].Dist
+	})
+	if q.Count > 0 && len(res) > q.Count {
+		res = res[:q.Count]
+	}
+	return res, nil
+}
+
+// GeoRem 删除成员 返回删除数量
+func (c *cache) GeoRem(key string, members ...string) int {
+	if c.isClosed() {
+		return 0
+	}
+	c.geo_mu.Lock()
+	n := 0
+	if g, ok := c.geoItems[key]; ok {
+		for _, member := range members {
+			if g.remove(member) {
+				n++
+			}
+		}
+	}
+	c.geo_mu.Unlock()
+	if n > 0 {
+		c.stats.delete(TypeGeo)
+		c.notify(EventDelete, TypeGeo, key)
+	}
+	return n
+}
+
+// GeoSetEx 设置过期时间 用于让长时间没有更新的位置自动过期，key不存在返回false
+func (c *cache) GeoSetEx(key string, d time.Duration, callBack bool) bool {
+	if c.isClosed() {
+		return false
+	}
+	var endTime int64
+	if d > 0 {
+		endTime = c.clock.Now().Add(d).Unix()
+	}
+	c.geo_mu.Lock()
+	g, ok := c.geoItems[key]
+	if !ok {
+		c.geo_mu.Unlock()
+		return false
+	}
+	oldExpiration := g.Expiration
+	g.Expiration = endTime
+	g.CallBack = callBack
+	c.geo_mu.Unlock()
+	if d > 0 {
+		c.timeWheel.AddTimer(d, timerKey{TypeGeo, key}, g) //替换原有的定时器
+	} else if oldExpiration > 0 {
+		c.timeWheel.RemoveTimer(timerKey{TypeGeo, key})
+	}
+	c.notify(EventUpdate, TypeGeo, key)
+	return true
+}
+
+// GeoDel 删除整个地理位置集合 设置了回调时以全部成员位置作为回调参数
+func (c *cache) GeoDel(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.geo_mu.Lock()
+	g, ok := c.geoItems[key]
+	if ok {
+		delete(c.geoItems, key)
+	}
+	c.geo_mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.stats.delete(TypeGeo)
+	if g.Expiration > 0 {
+		c.timeWheel.RemoveTimer(timerKey{TypeGeo, key})
+	}
+	c.notify(EventDelete, TypeGeo, key)
+	if g.CallBack {
+		c.invokeCallBack(TypeGeo, ReasonDeleted, key, g.locations())
+	}
+	return true
+}
+
+// geoExpire 时间轮到期删除 只删除定时器对应的那个集合
+func (c *cache) geoExpire(g *geoItem) {
+	now := c.clock.Now().Unix()
+	c.geo_mu.Lock()
+	cur, ok := c.geoItems[g.Key]
+	ok = ok && cur == g && g.expired(now) //去掉或延长过期时间后旧定时器不再生效
+	if ok {
+		delete(c.geoItems, g.Key)
+	}
+	c.geo_mu.Unlock()
+	if !ok {
+		return
+	}
+	c.stats.expire(TypeGeo)
+	c.notify(EventExpire, TypeGeo, g.Key)
+	if g.CallBack {
+		c.invokeCallBack(TypeGeo, ReasonExpired, g.Key, g.locations())
+	}
+}
+
+// geoMember 获取成员的geohash 调用方需持有geo_mu锁
+func (c *cache) geoMember(key, member string) (uint64, bool) {
+	g, ok := c.geoItems[key]
+	ok = ok && !g.expired(c.clock.Now().Unix())
+	var hash uint64
+	if ok {
+		hash, ok = g.members[member]
+	}
+	c.stats.lookup(TypeGeo, ok)
+	return hash, ok
+}
+
+func geoUnit(u GeoUnit) GeoUnit {
+	if u <= 0 {
+		return Meters
+	}
+	return u
+}
+
+func geoValid(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+// geoEncode 将经纬度编码为52位geohash 纬度在偶数位，经度在奇数位
+func geoEncode(lon, lat float64) uint64 {
+	latOffset := (lat - geoLatMin) / (geoLatMax - geoLatMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	latBits := uint32(math.Min(latOffset*(1<<geoStep), 1<<geoStep-1))
+	lonBits := uint32(math.Min(lonOffset*(1<<geoStep), 1<<geoStep-1))
+	return interleave(latBits, lonBits)
+}
+
+// geoDecode 将geohash解码为格子中心的经纬度
+func geoDecode(hash uint64) (float64, float64) {
+	latBits, lonBits := deinterleave(hash)
+	latUnit := (geoLatMax - geoLatMin) / (1 << geoStep)
+	lonUnit := (geoLonMax - geoLonMin) / (1 << geoStep)
+	lat := geoLatMin + (float64(latBits)+0.5)*latUnit
+	lon := geoLonMin + (float64(lonBits)+0.5)*lonUnit
+	return math.Max(geoLonMin, math.Min(geoLonMax, lon)), math.Max(geoLatMin, math.Min(geoLatMax, lat))
+}
+
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func deinterleave(v uint64) (uint32, uint32) {
+	return squash(v), squash(v >> 1)
+}
+
+// spread 将32位整数的每一位间隔一位展开
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+// geoDistance 半正矢公式计算两点距离 单位米
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := lat1*geoDegToRadians, lat2*geoDegToRadians
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * geoDegToRadians / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoInBox 判断点是否在以中心点为中心、宽width高height(米)的矩形内
+func geoInBox(lon, lat, pLon, pLat, width, height float64) bool {
+	if geoDistance(lon, lat, lon, pLat) > height/2 {
+		return false
+	}
+	return geoDistance(lon, pLat, pLon, pLat) <= width/2
+}
+
+// geoRanges 计算覆盖中心点radius范围的9个格子对应的geohash区间[min, max)
+func geoRanges(lon, lat, radius float64) [][2]uint64 {
+	step := geoEstimateStep(radius, lat)
+	latUnit := (geoLatMax - geoLatMin) / float64(uint64(1)<<step)
+	lonUnit := (geoLonMax - geoLonMin) / float64(uint64(1)<<step)
+	shift := uint(2 * (geoStep - step))
+	seen := map[uint64]bool{}
+	var ranges [][2]uint64
+	for _, dLat := range []float64{-1, 0, 1} {
+		for _, dLon := range []float64{-1, 0, 1} {
+			nLat := lat + dLat*latUnit
+			nLon := lon + dLon*lonUnit
+			if nLat < geoLatMin || nLat > geoLatMax {
+				continue
+			}
+			if nLon < geoLonMin {
+				nLon += 360
+			} else if nLon > geoLonMax {
+				nLon -= 360
+			}
+			cell := geoEncode(nLon, nLat) >> shift
+			if seen[cell] {
+				continue
+			}
+			seen[cell] = true
+			ranges = append(ranges, [2]uint64{cell << shift, (cell + 1) << shift})
+		}
+	}
+	return ranges
+}
+
+// geoEstimateStep 选择格子精度 保证格子的宽和高都不小于半径，这样中心格子加周围8个格子能覆盖查询范围
+func geoEstimateStep(radius, lat float64) uint {
+	if radius <= 0 {
+		return geoStep
+	}
+	step := uint(1)
+	for r := radius; r < geoMercatorMax && step < geoStep; r *= 2 {
+		step++
+	}
+	// 查询范围内离赤道最远的纬度上格子最窄
+	farLat := math.Min(math.Abs(lat)+radius/geoEarthRadius/geoDegToRadians, 89.9)
+	for step > 1 {
+		cellHeight := (geoLatMax - geoLatMin) / float64(uint64(1)<<step) * geoDegToRadians * geoEarthRadius
+		cellWidth := (geoLonMax - geoLonMin) / float64(uint64(1)<<step) * geoDegToRadians * geoEarthRadius * math.Cos(farLat*geoDegToRadians)
+		if cellHeight >= radius && cellWidth >= radius {
+			break
+		}
+		step--
+	}
+	return step
+}
@@ -0,0 +1,131 @@
+package speed
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGeo(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n, err := c.GeoAdd("Sicily",
+		GeoLocation{Name: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		GeoLocation{Name: "Catania", Longitude: 15.087269, Latitude: 37.502669},
+	)
+	if err != nil || n != 2 {
+		t.Fatalf("GeoAdd = %d, %v", n, err)
+	}
+	if _, err := c.GeoAdd("Sicily", GeoLocation{Name: "Pole", Longitude: 0, Latitude: 90}); err != ErrInvalidCoordinates {
+		t.Fatalf("err = %v, want ErrInvalidCoordinates", err)
+	}
+	if lon, lat, ok := c.GeoPos("Sicily", "Palermo"); !ok || math.Abs(lon-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Fatalf("GeoPos = %v, %v, %v", lon, lat, ok)
+	}
+	if d, ok := c.GeoDist("Sicily", "Palermo", "Catania", Kilometers); !ok || math.Abs(d-166.2742) > 0.001 {
+		t.Fatalf("GeoDist = %v, %v", d, ok)
+	}
+	res, err := c.GeoRadius("Sicily", 15, 37, 200, Kilometers, 0)
+	if err != nil || len(res) != 2 || res[0].Name != "Catania" || res[1].Name != "Palermo" {
+		t.Fatalf("GeoRadius = %+v, %v", res, err)
+	}
+	if math.Abs(res[0].Dist-56.4413) > 0.001 || math.Abs(res[1].Dist-190.4424) > 0.001 {
+		t.Fatalf("GeoRadius distances = %v, %v", res[0].Dist, res[1].Dist)
+	}
+	if res, _ := c.GeoRadius("Sicily", 15, 37, 100, Kilometers, 0); len(res) != 1 || res[0].Name != "Catania" {
+		t.Fatalf("GeoRadius(100km) = %+v", res)
+	}
+	res, _ = c.GeoSearch("Sicily", GeoQuery{Longitude: 15, Latitude: 37, Width: 400, Height: 400, Unit: Kilometers, Desc: true, Count: 1})
+	if len(res) != 1 || res[0].Name != "Palermo" {
+		t.Fatalf("GeoSearch box = %+v", res)
+	}
+	if res, _ := c.GeoRadiusByMember("Sicily", "Palermo", 10, Kilometers, 0); len(res) != 1 || res[0].Name != "Palermo" {
+		t.Fatalf("GeoRadiusByMember = %+v", res)
+	}
+	if c.GeoRem("Sicily", "Palermo", "Rome") != 1 {
+		t.Fatal("GeoRem should remove one member")
+	}
+	if _, ok := c.GeoDist("Sicily", "Palermo", "Catania", Meters); ok {
+		t.Fatal("removed member still has a distance")
+	}
+}
+
+func TestGeoSearchMatchesScan(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	r := rand.New(rand.NewSource(1))
+	var all []GeoLocation
+	for i := 0; i < 2000; i++ {
+		loc := GeoLocation{Name: strconv.Itoa(i), Longitude: 116 + r.Float64(), Latitude: 39.5 + r.Float64()}
+		all = append(all, loc)
+		c.GeoAdd("drivers", loc)
+	}
+	for _, radius := range []float64{500, 5000, 30000} {
+		res, _ := c.GeoRadius("drivers", 116.4, 39.9, radius, Meters, 0)
+		want := 0
+		for _, loc := range all {
+			lon, lat, _ := c.GeoPos("drivers", loc.Name)
+			if geoDistance(116.4, 39.9, lon, lat) <= radius {
+				want++
+			}
+		}
+		if len(res) != want {
+			t.Fatalf("radius %v: got %d members, want %d", radius, len(res), want)
+		}
+		for i := 1; i < len(res); i++ {
+			if res[i].Dist < res[i-1].Dist {
+				t.Fatalf("results not sorted by distance at %d", i)
+			}
+		}
+	}
+}
+
+func TestGeoExpire(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	events := make(chan DeleteEvent, 1)
+	c.BindDeleteHandler(func(ev DeleteEvent) {
+		events <- ev
+	})
+	c.GeoAdd("drivers", GeoLocation{Name: "1001", Longitude: 116.4, Latitude: 39.9})
+	if !c.GeoSetEx("drivers", time.Second, true) {
+		t.Fatal("GeoSetEx failed")
+	}
+	select {
+	case ev := <-events:
+		locs, _ := ev.Value.([]GeoLocation)
+		if ev.Type != TypeGeo || ev.Reason != ReasonExpired || len(locs) != 1 || locs[0].Name != "1001" {
+			t.Fatalf("unexpected callback %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("geo key did not expire")
+	}
+	if _, _, ok := c.GeoPos("drivers", "1001"); ok {
+		t.Fatal("expired key still readable")
+	}
+}
+
+func TestGeoStaleExpire(t *testing.T) {
+	c, _ := newFakeCache(t)
+	c.GeoAdd("drivers", GeoLocation{Name: "1001", Longitude: 116.4, Latitude: 39.9})
+	c.GeoSetEx("drivers", time.Second*10, true)
+	c.geo_mu.RLock()
+	g := c.geoItems["drivers"]
+	c.geo_mu.RUnlock()
+	c.GeoSetEx("drivers", 0, true) //去掉过期时间，已经到期的旧定时器不能删除key
+	c.expire(g)
+	if _, _, ok := c.GeoPos("drivers", "1001"); !ok {
+		t.Fatal("stale timer deleted the key")
+	}
+}
@@ -0,0 +1,263 @@
+package speed
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	hllP         = 14                         //索引位数
+	hllRegisters = 1 << hllP                  //寄存器数量 16384
+	hllBits      = 6                          //每个寄存器6位
+	hllBytes     = hllRegisters * hllBits / 8 //寄存器占用 12KB
+)
+
+// hllItem HyperLogLog 固定12KB，标准误差约0.81%
+type hllItem struct {
+	Key        string
+	registers  []byte //16384个6位寄存器紧凑存储
+	Expiration int64  //过期时间
+	CallBack   bool   //是否回调
+}
+
+func newHLLItem(key string) *hllItem {
+	return &hllItem{Key: key, registers: make([]byte, hllBytes)}
+}
+
+func (h *hllItem) get(i int) uint8 {
+	pos := i * hllBits
+	b, shift := pos/8, uint(pos%8)
+	v := uint16(h.registers[b])
+	if shift > 8-hllBits {
+		v |= uint16(h.registers[b+1]) << 8
+	}
+	return uint8(v>>shift) & (1<<hllBits - 1)
+}
+
+func (h *hllItem) set(i int, val uint8) {
+	pos := i * hllBits
+	b, shift := pos/8, uint(pos%8)
+	v := uint16(h.registers[b])
+	if shift > 8-hllBits {
+		v |= uint16(h.registers[b+1]) << 8
+	}
+	v &^= (1<<hllBits - 1) << shift
+	v |= uint16(val) << shift
+	h.registers[b] = byte(v)
+	if shift > 8-hllBits {
+		h.registers[b+1] = byte(v >> 8)
+	}
+}
+
+// add 添加元素 返回是否有寄存器被修改
+func (h *hllItem) add(element interface{}) bool {
+	x := hash64(element)
+	i := int(x & (hllRegisters - 1))
+	rank := uint8(bits.TrailingZeros64(x>>hllP|1<<(64-hllP)) + 1)
+	if h.get(i) >= rank {
+		return false
+	}
+	h.set(i, rank)
+	return true
+}
+
+// merge 合并 每个寄存器取最大值
+func (h *hllItem) merge(o *hllItem) {
+	for i := 0; i < hllRegisters; i++ {
+		if v := o.get(i); v > h.get(i) {
+			h.set(i, v)
+		}
+	}
+}
+
+// count 估算基数 基数较小时使用线性计数修正
+func (h *hllItem) count() uint64 {
+	const m = float64(hllRegisters)
+	sum, zeros := 0.0, 0
+	for i := 0; i < hllRegisters; i++ {
+		v := h.get(i)
+		if v == 0 {
+			zeros++
+		}
+		sum += 1 / float64(uint64(1)<<v)
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+func (h *hllItem) expired(now int64) bool {
+	return h.Expiration > 0 && h.Expiration <= now
+}
+
+// hash64 计算元素的64位哈希 fnv-1a之后再做一次混合，保证低位分布均匀
+func hash64(element interface{}) uint64 {
+	f := fnv.New64a()
+	switch v := element.(type) {
+	case string:
+		f.Write([]byte(v))
+	case []byte:
+		f.Write(v)
+	default:
+		fmt.Fprint(f, v)
+	}
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// PFAdd 向HyperLogLog添加元素 key不存在时创建，返回估算值是否可能发生变化
+func (c *cache) PFAdd(key string, elements ...interface{}) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.hll_mu.Lock()
+	h, ok := c.hllItems[key]
+	if !ok {
+		h = newHLLItem(key)
+		c.hllItems[key] = h
+	}
+	changed := !ok
+	for _, element := range elements {
+		if h.add(element) {
+			changed = true
+		}
+	}
+	c.hll_mu.Unlock()
+	c.stats.set(TypeHLL)
+	if changed {
+		c.notify(writeEvent(ok), TypeHLL, key)
+	}
+	return changed
+}
+
+// PFCount 估算基数 多个key时估算并集的基数
+func (c *cache) PFCount(keys ...string) uint64 {
+	c.hll_mu.RLock()
+	defer c.hll_mu.RUnlock()
+	now := c.clock.Now().Unix()
+	var union *hllItem
+	for _, key := range keys {
+		h, ok := c.hllItems[key]
+		ok = ok && !h.expired(now)
+		c.stats.lookup(TypeHLL, ok)
+		if !ok {
+			continue
+		}
+		if len(keys) == 1 {
+			return h.count()
+		}
+		if union == nil {
+			union = newHLLItem("")
+		}
+		union.merge(h)
+	}
+	if union == nil {
+		return 0
+	}
+	return union.count()
+}
+
+// PFMerge 将sources合并到dest dest不存在时创建，保留dest原有的过期时间
+func (c *cache) PFMerge(dest string, sources ...string) {
+	if c.isClosed() {
+		return
+	}
+	c.hll_mu.Lock()
+	h, ok := c.hllItems[dest]
+	if !ok {
+		h = newHLLItem(dest)
+		c.hllItems[dest] = h
+	}
+	for _, key := range sources {
+		if src, exists := c.hllItems[key]; exists && src != h {
+			h.merge(src)
+		}
+	}
+	c.hll_mu.Unlock()
+	c.stats.set(TypeHLL)
+	c.notify(writeEvent(ok), TypeHLL, dest)
+}
+
+// PFSetEx 设置HyperLogLog过期时间 d为0时永不过期，key不存在返回false
+func (c *cache) PFSetEx(key string, d time.Duration, callBack bool) bool {
+	if c.isClosed() {
+		return false
+	}
+	var endTime int64
+	if d > 0 {
+		endTime = c.clock.Now().Add(d).Unix()
+	}
+	c.hll_mu.Lock()
+	h, ok := c.hllItems[key]
+	if !ok {
+		c.hll_mu.Unlock()
+		return false
+	}
+	oldExpiration := h.Expiration
+	h.Expiration = endTime
+	h.CallBack = callBack
+	c.hll_mu.Unlock()
+	if d > 0 {
+		c.timeWheel.AddTimer(d, timerKey{TypeHLL, key}, h) //替换原有的定时器
+	} else if oldExpiration > 0 {
+		c.timeWheel.RemoveTimer(timerKey{TypeHLL, key})
+	}
+	c.notify(EventUpdate, TypeHLL, key)
+	return true
+}
+
+// PFDel 删除HyperLogLog 设置了回调时以估算值作为回调参数
+func (c *cache) PFDel(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.hll_mu.Lock()
+	h, ok := c.hllItems[key]
+	if ok {
+		delete(c.hllItems, key)
+	}
+	c.hll_mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.stats.delete(TypeHLL)
+	if h.Expiration > 0 {
+		c.timeWheel.RemoveTimer(timerKey{TypeHLL, key})
+	}
+	c.notify(EventDelete, TypeHLL, key)
+	if h.CallBack {
+		c.invokeCallBack(TypeHLL, ReasonDeleted, key, h.count())
+	}
+	return true
+}
+
+// hllExpire 时间轮到期删除 只删除定时器对应的那个HyperLogLog
+func (c *cache) hllExpire(h *hllItem) {
+	now := c.clock.Now().Unix()
+	c.hll_mu.Lock()
+	cur, ok := c.hllItems[h.Key]
+	ok = ok && cur == h && h.expired(now) //去掉或延长过期时间后旧定时器不再生效
+	if ok {
+		delete(c.hllItems, h.Key)
+	}
+	c.hll_mu.Unlock()
+	if !ok {
+		return
+	}
+	c.stats.expire(TypeHLL)
+	c.notify(EventExpire, TypeHLL, h.Key)
+	if h.CallBack {
+		c.invokeCallBack(TypeHLL, ReasonExpired, h.Key, h.count())
+	}
+}
@@ -0,0 +1,78 @@
+package speed
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	const n = 100000
+	for i := 0; i < n; i++ {
+		c.PFAdd("page:1", "user-"+strconv.Itoa(i))
+		if i%2 == 0 {
+			c.PFAdd("page:2", "user-"+strconv.Itoa(i))
+		}
+	}
+	for key, want := range map[string]float64{"page:1": n, "page:2": n / 2} {
+		got := float64(c.PFCount(key))
+		if e := math.Abs(got-want) / want; e > 0.03 {
+			t.Errorf("PFCount(%s) = %v, want %v (error %.4f)", key, got, want, e)
+		}
+	}
+	if c.PFAdd("page:1", "user-1") {
+		t.Error("PFAdd of an existing element reported a change")
+	}
+	if got := c.PFCount("page:1", "page:2"); math.Abs(float64(got)-n)/n > 0.03 {
+		t.Errorf("union count = %d, want about %d", got, n)
+	}
+	c.PFMerge("all", "page:1", "page:2")
+	if got, want := c.PFCount("all"), c.PFCount("page:1"); got != want {
+		t.Errorf("merged count = %d, want %d", got, want)
+	}
+	if c.PFCount("small") != 0 {
+		t.Error("missing key should count 0")
+	}
+	c.PFAdd("small", "a", "b", "c")
+	if got := c.PFCount("small"); got != 3 {
+		t.Errorf("PFCount(small) = %d, want 3", got)
+	}
+	if !c.PFDel("small") || c.PFCount("small") != 0 {
+		t.Error("PFDel did not remove the key")
+	}
+}
+
+func TestHLLRegisters(t *testing.T) {
+	h := newHLLItem("")
+	if len(h.registers) != 12288 {
+		t.Fatalf("register size = %d, want 12288", len(h.registers))
+	}
+	for i := 0; i < hllRegisters; i++ {
+		h.set(i, uint8(i%64))
+	}
+	for i := 0; i < hllRegisters; i++ {
+		if v := h.get(i); v != uint8(i%64) {
+			t.Fatalf("register %d = %d, want %d", i, v, i%64)
+		}
+	}
+}
+
+func TestHLLStaleExpire(t *testing.T) {
+	c, _ := newFakeCache(t)
+	c.PFAdd("uv", "a", "b")
+	c.PFSetEx("uv", time.Second*10, true)
+	c.hll_mu.RLock()
+	h := c.hllItems["uv"]
+	c.hll_mu.RUnlock()
+	c.PFSetEx("uv", 0, true) //去掉过期时间，已经到期的旧定时器不能删除key
+	c.expire(h)
+	if n := c.PFCount("uv"); n != 2 {
+		t.Fatalf("PFCount = %d, want 2", n)
+	}
+}
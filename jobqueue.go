@@ -0,0 +1,397 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed 任务队列已关闭
+var ErrQueueClosed = errors.New("job queue closed")
+
+// JobHandler 任务处理函数 返回错误时按指数退避重试，超过最大次数进入死信列表
+type JobHandler func(ctx context.Context, job DelayedJob) error
+
+// JobState 任务状态
+type JobState int
+
+const (
+	JobScheduled JobState = iota //等待到期
+	JobReady                     //已到期等待执行
+	JobRunning                   //执行中
+	JobFailed                    //超过最大重试次数，在死信列表中
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobScheduled:
+		return "scheduled"
+	case JobReady:
+		return "ready"
+	case JobRunning:
+		return "running"
+	case JobFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// DelayedJob 延时任务快照
+type DelayedJob struct {
+	ID        ID
+	Payload   interface{}
+	State     JobState
+	Attempts  int       //已执行次数
+	RunAt     time.Time //下次执行时间
+	CreatedAt time.Time
+	LastError error
+}
+
+// JobQueueStats 任务队列统计
+type JobQueueStats struct {
+	Scheduled int
+	Ready     int
+	Running   int
+	Failed    int
+	Succeeded uint64 //累计成功次数
+	Retried   uint64 //累计重试次数
+}
+
+// JobOption 任务队列配置项
+type JobOption func(*jobOptions)
+
+type jobOptions struct {
+	workers     int           //工作协程数量
+	maxAttempts int           //最大执行次数
+	backoff     time.Duration //第一次重试的等待时间，之后每次翻倍
+	maxBackoff  time.Duration //重试等待时间上限
+}
+
+// WithJobWorkers 设置工作协程数量 默认1
+func WithJobWorkers(n int) JobOption {
+	return func(o *jobOptions) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithJobMaxAttempts 设置最大执行次数 默认3，超过后进入死信列表
+func WithJobMaxAttempts(n int) JobOption {
+	return func(o *jobOptions) {
+		if n > 0 {
+			o.maxAttempts = n
+		}
+	}
+}
+
+// WithJobBackoff 设置重试等待时间 第n次重试等待base*2^(n-1)，不超过max，默认1秒、1小时
+func WithJobBackoff(base, max time.Duration) JobOption {
+	return func(o *jobOptions) {
+		if base > 0 {
+			o.backoff = base
+		}
+		if max >= base {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// job 任务 所有字段由JobQueue.mu保护
+type job struct {
+	DelayedJob
+	q *JobQueue
+}
+
+// jobTimerKey 任务在时间轮中的标识
+type jobTimerKey struct {
+	q  *JobQueue
+	id ID
+}
+
+// JobQueue 基于时间轮的延时任务队列
+type JobQueue struct {
+	c       *cache
+	handler JobHandler
+	opts    jobOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	wake    chan struct{}
+
+	mu        sync.Mutex
+	jobs      map[ID]*job //等待、执行中的任务
+	ready     []*job      //已到期的任务 先进先出
+	failed    map[ID]*job //死信列表
+	succeeded uint64
+	retried   uint64
+}
+
+// NewJobQueue 创建任务队列并启动工作协程 缓存停止或调用Close后工作协程退出
+func (c *cache) NewJobQueue(handler JobHandler, opts ...JobOption) *JobQueue {
+	o := jobOptions{workers: 1, maxAttempts: 3, backoff: time.Second, maxBackoff: time.Hour}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	q := &JobQueue{
+		c:       c,
+		handler: handler,
+		opts:    o,
+		ctx:     ctx,
+		cancel:  cancel,
+		wake:    make(chan struct{}, o.workers),
+		jobs:    map[ID]*job{},
+		failed:  map[ID]*job{},
+	}
+	for i := 0; i < o.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue 添加任务 delay后执行，0为立即执行
+func (q *JobQueue) Enqueue(payload interface{}, delay time.Duration) (ID, error) {
+	if q.ctx.Err() != nil {
+		return 0, ErrQueueClosed
+	}
+	now := q.c.clock.Now()
+	j := &job{DelayedJob: DelayedJob{ID: q.c.snowflake.Generate(), Payload: payload, CreatedAt: now, RunAt: now.Add(delay)}, q: q}
+	q.mu.Lock()
+	q.jobs[j.ID] = j
+	q.mu.Unlock()
+	q.schedule(j, delay)
+	return j.ID, nil
+}
+
+// schedule 延迟大于0时加入时间轮，否则直接进入就绪队列
+func (q *JobQueue) schedule(j *job, delay time.Duration) {
+	if delay > 0 {
+		q.mu.Lock()
+		j.State = JobScheduled
+		q.mu.Unlock()
+		q.c.timeWheel.AddTimer(delay, jobTimerKey{q, j.ID}, j)
+		return
+	}
+	q.push(j)
+}
+
+// push 任务到期 由时间轮协程调用，不能阻塞
+func (q *JobQueue) push(j *job) {
+	q.mu.Lock()
+	if q.jobs[j.ID] != j {
+		q.mu.Unlock()
+		return //已取消
+	}
+	j.State = JobReady
+	q.ready = append(q.ready, j)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) pop() *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ready) == 0 {
+		return nil
+	}
+	j := q.ready[0]
+	q.ready[0] = nil
+	q.ready = q.ready[1:]
+	j.State = JobRunning
+	j.Attempts++
+	return j
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for {
+		if j := q.pop(); j != nil {
+			q.finish(j, q.call(j))
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// call 执行任务 panic视为执行失败
+func (q *JobQueue) call(j *job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %v panic: %v\n%s", j.ID, r, debug.Stack())
+		}
+	}()
+	q.mu.Lock()
+	snapshot := j.DelayedJob
+	q.mu.Unlock()
+	return q.handler(q.ctx, snapshot)
+}
+
+// finish 成功则删除任务，失败则按指数退避重试或进入死信列表
+func (q *JobQueue) finish(j *job, err error) {
+	q.mu.Lock()
+	if err == nil {
+		delete(q.jobs, j.ID)
+		q.succeeded++
+		q.mu.Unlock()
+		return
+	}
+	j.LastError = err
+	if j.Attempts >= q.opts.maxAttempts {
+		delete(q.jobs, j.ID)
+		j.State = JobFailed
+		q.failed[j.ID] = j
+		q.mu.Unlock()
+		return
+	}
+	delay := q.backoff(j.Attempts)
+	j.RunAt = q.c.clock.Now().Add(delay)
+	q.retried++
+	q.mu.Unlock()
+	q.schedule(j, delay)
+}
+
+// backoff 第attempts次执行失败后的等待时间
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	d := q.opts.backoff
+	for i := 1; i < attempts && d < q.opts.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.opts.maxBackoff {
+		d = q.opts.maxBackoff
+	}
+	return d
+}
+
+// Cancel 取消等待执行的任务 执行中的任务无法取消
+func (q *JobQueue) Cancel(id ID) bool {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok || j.State == JobRunning {
+		q.mu.Unlock()
+		return false
+	}
+	delete(q.jobs, id)
+	scheduled := j.State == JobScheduled
+	if j.State == JobReady {
+		for i, r := range q.ready {
+			if r == j {
+				q.ready = append(q.ready[:i], q.ready[i+1:]...)
+				break
+			}
+		}
+	}
+	q.mu.Unlock()
+	if scheduled { //移除时间轮中的定时器，避免长延迟的任务取消后定时器一直残留
+		q.c.timeWheel.RemoveTimer(jobTimerKey{q, id})
+	}
+	return true
+}
+
+// Get 获取任务快照 包括死信列表中的任务
+func (q *JobQueue) Get(id ID) (DelayedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.jobs[id]; ok {
+		return j.DelayedJob, true
+	}
+	if j, ok := q.failed[id]; ok {
+		return j.DelayedJob, true
+	}
+	return DelayedJob{}, false
+}
+
+// Pending 等待执行和执行中的任务 按执行时间排序
+func (q *JobQueue) Pending() []DelayedJob {
+	q.mu.Lock()
+	res := make([]DelayedJob, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		res = append(res, j.DelayedJob)
+	}
+	q.mu.Unlock()
+	sortJobs(res)
+	return res
+}
+
+// Failed 死信列表 按最后执行时间排序
+func (q *JobQueue) Failed() []DelayedJob {
+	q.mu.Lock()
+	res := make([]DelayedJob, 0, len(q.failed))
+	for _, j := range q.failed {
+		res = append(res, j.DelayedJob)
+	}
+	q.mu.Unlock()
+	sortJobs(res)
+	return res
+}
+
+// Retry 将死信列表中的任务重新加入队列 执行次数清零
+func (q *JobQueue) Retry(id ID) bool {
+	q.mu.Lock()
+	j, ok := q.failed[id]
+	if ok {
+		delete(q.failed, id)
+		j.Attempts = 0
+		j.RunAt = q.c.clock.Now()
+		q.jobs[id] = j
+	}
+	q.mu.Unlock()
+	if ok {
+		q.push(j)
+	}
+	return ok
+}
+
+// RemoveFailed 从死信列表删除任务
+func (q *JobQueue) RemoveFailed(id ID) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.failed[id]
+	delete(q.failed, id)
+	return ok
+}
+
+// Stats 任务队列统计
+func (q *JobQueue) Stats() JobQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := JobQueueStats{Failed: len(q.failed), Succeeded: q.succeeded, Retried: q.retried}
+	for _, j := range q.jobs {
+		switch j.State {
+		case JobScheduled:
+			s.Scheduled++
+		case JobReady:
+			s.Ready++
+		case JobRunning:
+			s.Running++
+		}
+	}
+	return s
+}
+
+// Close 停止工作协程 等待执行中的任务结束，未执行的任务保留在队列中可通过Pending查看
+func (q *JobQueue) Close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func sortJobs(jobs []DelayedJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].RunAt.Before(jobs[j].RunAt)
+	})
+}
@@ -0,0 +1,115 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitUntil 轮询等待条件成立 用于依赖时间轮(秒级)的测试
+func waitUntil(t *testing.T, d time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	done := make(chan interface{}, 10)
+	q := c.NewJobQueue(func(ctx context.Context, job DelayedJob) error {
+		done <- job.Payload
+		return nil
+	}, WithJobWorkers(2))
+	defer q.Close()
+
+	delayed, _ := q.Enqueue("later", time.Second)
+	q.Enqueue("now", 0)
+	if v := <-done; v != "now" {
+		t.Fatalf("first job = %v, want now", v)
+	}
+	if job, ok := q.Get(delayed); !ok || job.State != JobScheduled {
+		t.Fatalf("delayed job = %+v, %v", job, ok)
+	}
+	if p := q.Pending(); len(p) != 1 || p[0].ID != delayed {
+		t.Fatalf("Pending = %+v", p)
+	}
+	select {
+	case v := <-done:
+		if v != "later" {
+			t.Fatalf("second job = %v", v)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("delayed job did not run")
+	}
+	waitUntil(t, time.Second, func() bool { return q.Stats().Succeeded == 2 })
+
+	id, _ := q.Enqueue("cancelled", 30*24*time.Hour)
+	waitUntil(t, time.Second, func() bool { return c.timeWheel.Pending() == 1 })
+	if !q.Cancel(id) || q.Cancel(id) {
+		t.Fatal("Cancel should succeed once")
+	}
+	waitUntil(t, time.Second, func() bool { return c.timeWheel.Pending() == 0 }) //取消后定时器不再残留
+	q.Close()
+	if _, err := q.Enqueue("x", 0); err != ErrQueueClosed {
+		t.Fatalf("err = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestJobQueueRetry(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	var calls int32
+	boom := errors.New("boom")
+	q := c.NewJobQueue(func(ctx context.Context, job DelayedJob) error {
+		if atomic.AddInt32(&calls, 1) == 4 {
+			panic("handler panic")
+		}
+		return boom
+	}, WithJobMaxAttempts(3), WithJobBackoff(time.Millisecond, time.Millisecond))
+	defer q.Close()
+
+	id, _ := q.Enqueue("task", 0)
+	waitUntil(t, 5*time.Second, func() bool { return len(q.Failed()) == 1 })
+	job := q.Failed()[0]
+	if job.ID != id || job.Attempts != 3 || job.State != JobFailed || !errors.Is(job.LastError, boom) {
+		t.Fatalf("dead letter = %+v", job)
+	}
+	if s := q.Stats(); s.Retried != 2 || s.Failed != 1 || s.Scheduled+s.Ready+s.Running != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	if !q.Retry(id) {
+		t.Fatal("Retry failed")
+	}
+	waitUntil(t, 5*time.Second, func() bool { return atomic.LoadInt32(&calls) >= 4 })
+	waitUntil(t, time.Second, func() bool {
+		j, ok := q.Get(id)
+		return ok && j.State != JobRunning && j.LastError != nil && j.LastError != boom
+	})
+	if !q.RemoveFailed(id) && !q.Cancel(id) {
+		t.Fatal("job disappeared after a panic")
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	q := &JobQueue{opts: jobOptions{backoff: time.Second, maxBackoff: 5 * time.Second}}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
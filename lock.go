@@ -0,0 +1,235 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrLockHeld    = errors.New("lock is held by another owner")
+	ErrLockNotHeld = errors.New("lock is not held by this owner")
+)
+
+// lockItem 锁状态 时间为纳秒
+type lockItem struct {
+	Key        string
+	Token      ID     //持有者标识
+	Fence      uint64 //防护令牌
+	Expiration int64  //过期时间 0为永不过期
+	released   chan struct{}
+}
+
+func (l *lockItem) expired(now int64) bool {
+	return l.Expiration > 0 && l.Expiration <= now
+}
+
+// Lock 已获取的锁
+type Lock struct {
+	c     *cache
+	Key   string
+	Token ID     //持有者标识 释放、续期时校验
+	Fence uint64 //防护令牌 单调递增，下游可拒绝比已见过的更小的令牌
+}
+
+// Locker 带租期的互斥锁 租期到期未续期时自动释放
+type Locker struct {
+	c   *cache
+	ttl time.Duration
+}
+
+// NewLocker 创建Locker ttl为锁的租期，0为永不过期
+func (c *cache) NewLocker(ttl time.Duration) *Locker {
+	return &Locker{c: c, ttl: ttl}
+}
+
+// TryAcquire 尝试获取锁 已被持有时返回ErrLockHeld
+func (l *Locker) TryAcquire(key string) (*Lock, error) {
+	if l.c.isClosed() {
+		return nil, ErrClosed
+	}
+	lock, _ := l.c.tryLock(key, l.ttl)
+	if lock == nil {
+		return nil, ErrLockHeld
+	}
+	return lock, nil
+}
+
+// Acquire 阻塞获取锁 直到成功或ctx结束
+func (l *Locker) Acquire(ctx context.Context, key string) (*Lock, error) {
+	for {
+		if l.c.isClosed() {
+			return nil, ErrClosed
+		}
+		lock, wait := l.c.tryLock(key, l.ttl)
+		if lock != nil {
+			return lock, nil
+		}
+		if err := l.c.waitRelease(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockWait 锁被持有时等待的条件 持有者释放或租期到期
+type lockWait struct {
+	released <-chan struct{}
+	timeout  time.Duration //0为不会到期
+}
+
+// waitRelease 等待持有者释放、租期到期或ctx结束 租期按缓存时钟计算，缓存关闭时返回ErrClosed
+func (c *cache) waitRelease(ctx context.Context, w lockWait) error {
+	var expire chan struct{}
+	if w.timeout > 0 {
+		expire = make(chan struct{})
+		t := c.clock.AfterFunc(w.timeout, func() { close(expire) })
+		defer t.Stop()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closing:
+		return ErrClosed
+	case <-c.ctx.Done():
+		return ErrClosed
+	case <-w.released:
+	case <-expire:
+	}
+	return nil
+}
+
+// tryLock 获取锁 失败时返回等待条件
+func (c *cache) tryLock(key string, ttl time.Duration) (*Lock, lockWait) {
+	now := c.clock.Now().UnixNano()
+	c.lock_mu.Lock()
+	old, ok := c.lockItems[key]
+	if ok && !old.expired(now) {
+		w := lockWait{released: old.released}
+		if old.Expiration > 0 {
+			w.timeout = time.Duration(old.Expiration - now)
+		}
+		c.lock_mu.Unlock()
+		return nil, w
+	}
+	c.lockFence++
+	item := &lockItem{Key: key, Token: c.snowflake.Generate(), Fence: c.lockFence, released: make(chan struct{})}
+	if ttl > 0 {
+		item.Expiration = now + int64(ttl)
+	}
+	c.lockItems[key] = item
+	c.lock_mu.Unlock()
+	if ok {
+		close(old.released)
+		c.stats.expire(TypeLock)
+	}
+	c.stats.set(TypeLock)
+	c.notify(EventSet, TypeLock, key)
+	if ttl > 0 {
+		c.timeWheel.AddTimer(leaseTimerDelay(ttl), timerKey{TypeLock, key}, item)
+	}
+	return &Lock{c: c, Key: key, Token: item.Token, Fence: item.Fence}, lockWait{}
+}
+
+// Release 释放锁 锁已过期或已被其他持有者获取时返回ErrLockNotHeld
+func (l *Lock) Release() error {
+	if !l.c.ReleaseLock(l.Key, l.Token) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend 续期 租期从现在开始重新计算为ttl
+func (l *Lock) Extend(ttl time.Duration) error {
+	if !l.c.ExtendLock(l.Key, l.Token, ttl) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// TTL 锁的剩余租期 锁已丢失时返回ErrLockNotHeld，永不过期时返回0
+func (l *Lock) TTL() (time.Duration, error) {
+	now := l.c.clock.Now().UnixNano()
+	l.c.lock_mu.Lock()
+	defer l.c.lock_mu.Unlock()
+	item, ok := l.c.lockItems[l.Key]
+	if !ok || item.Token != l.Token || item.expired(now) {
+		return 0, ErrLockNotHeld
+	}
+	if item.Expiration == 0 {
+		return 0, nil
+	}
+	return time.Duration(item.Expiration - now), nil
+}
+
+// ReleaseLock 持有者标识一致时释放锁
+func (c *cache) ReleaseLock(key string, token ID) bool {
+	if c.isClosed() {
+		return false
+	}
+	now := c.clock.Now().UnixNano()
+	c.lock_mu.Lock()
+	item, ok := c.lockItems[key]
+	ok = ok && item.Token == token && !item.expired(now)
+	if ok {
+		delete(c.lockItems, key)
+	}
+	c.lock_mu.Unlock()
+	if !ok {
+		return false
+	}
+	//不删除定时器 到期时发现锁已被替换会直接忽略
+	close(item.released)
+	c.stats.delete(TypeLock)
+	c.notify(EventDelete, TypeLock, key)
+	return true
+}
+
+// ExtendLock 持有者标识一致时把租期重置为ttl ttl为0表示永不过期
+func (c *cache) ExtendLock(key string, token ID, ttl time.Duration) bool {
+	if c.isClosed() {
+		return false
+	}
+	now := c.clock.Now().UnixNano()
+	c.lock_mu.Lock()
+	item, ok := c.lockItems[key]
+	ok = ok && item.Token == token && !item.expired(now)
+	var scheduled bool
+	if ok {
+		scheduled = item.Expiration > 0
+		item.Expiration = 0
+		if ttl > 0 {
+			item.Expiration = now + int64(ttl)
+		}
+	}
+	c.lock_mu.Unlock()
+	if !ok {
+		return false
+	}
+	//已有定时器到期时按新的过期时间重新加入时间轮
+	if !scheduled && ttl > 0 {
+		c.timeWheel.AddTimer(leaseTimerDelay(ttl), timerKey{TypeLock, key}, item)
+	}
+	c.notify(EventUpdate, TypeLock, key)
+	return true
+}
+
+// lockExpire 时间轮到期 已续期的锁按剩余时间重新加入时间轮
+func (c *cache) lockExpire(item *lockItem) {
+	now := c.clock.Now().UnixNano()
+	c.lock_mu.Lock()
+	cur, ok := c.lockItems[item.Key]
+	if !ok || cur != item || item.Expiration == 0 {
+		c.lock_mu.Unlock()
+		return
+	}
+	if remain := time.Duration(item.Expiration - now); remain > 0 {
+		c.lock_mu.Unlock()
+		c.timeWheel.AddTimer(leaseTimerDelay(remain), timerKey{TypeLock, item.Key}, item)
+		return
+	}
+	delete(c.lockItems, item.Key)
+	c.lock_mu.Unlock()
+	close(item.released)
+	c.stats.expire(TypeLock)
+	c.notify(EventExpire, TypeLock, item.Key)
+}
@@ -0,0 +1,133 @@
+package speed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	locker := c.NewLocker(time.Minute)
+	l1, err := locker.TryAcquire("order:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locker.TryAcquire("order:1"); err != ErrLockHeld {
+		t.Fatalf("err = %v, want ErrLockHeld", err)
+	}
+	if c.ReleaseLock("order:1", l1.Token+1) {
+		t.Fatal("released with a foreign token")
+	}
+	if err := l1.Extend(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := l1.TTL(); ttl <= time.Minute {
+		t.Fatalf("TTL after Extend = %v", ttl)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		l, err := locker.Acquire(context.Background(), "order:1")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- l
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a held lock")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := l1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	var l2 *Lock
+	select {
+	case l2 = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken by Release")
+	}
+	if l2.Fence <= l1.Fence || l2.Token == l1.Token {
+		t.Fatalf("fence %d -> %d, token %v -> %v", l1.Fence, l2.Fence, l1.Token, l2.Token)
+	}
+	if err := l1.Release(); err != ErrLockNotHeld {
+		t.Fatalf("err = %v, want ErrLockNotHeld", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Acquire(ctx, "order:1"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestLockerLease(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	short := c.NewLocker(30 * time.Millisecond)
+	l1, _ := short.TryAcquire("job")
+	start := time.Now()
+	l2, err := short.Acquire(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond || l2.Fence <= l1.Fence {
+		t.Fatal("lease was taken over before it expired")
+	}
+	if err := l1.Extend(time.Minute); err != ErrLockNotHeld {
+		t.Fatalf("expired owner extended the lock: %v", err)
+	}
+
+	//时间轮清理过期的锁
+	c.NewLocker(time.Second).TryAcquire("crashed")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats()[TypeLock].Expirations < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("lock was not cleaned up by the time wheel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.NewLocker(0).TryAcquire("crashed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockerFakeClock(t *testing.T) {
+	c, clock := newFakeCache(t)
+	locker := c.NewLocker(10 * time.Second)
+	start := clock.Now()
+	l1, _ := locker.TryAcquire("job")
+	acquired := make(chan *Lock, 1)
+	go func() {
+		l, _ := locker.Acquire(context.Background(), "job")
+		acquired <- l
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a held lock")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(9900 * time.Millisecond)
+	var l2 *Lock
+	waitFor(t, func() bool {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case l2 = <-acquired:
+			return true
+		default:
+			return false
+		}
+	})
+	//等待者按缓存时钟在租期到期时醒来，不用等时间轮清理
+	if d := clock.Now().Sub(start); d > 10500*time.Millisecond || l2 == nil || l2.Fence <= l1.Fence {
+		t.Fatalf("lock = %+v acquired %v after the first lease", l2, d)
+	}
+}
@@ -0,0 +1,184 @@
+package speed
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// callBackBuckets 回调耗时直方图的桶上界 单位秒
+var callBackBuckets = [...]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// latencyHistogram 耗时直方图 全部字段通过atomic读写
+type latencyHistogram struct {
+	counts [len(callBackBuckets) + 1]uint64 //每个桶的计数 最后一个为+Inf
+	sum    uint64                           //耗时总和 单位纳秒
+	count  uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(callBackBuckets) && seconds > callBackBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// MetricsHandler 以Prometheus文本格式输出缓存指标
+func (c *cache) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics 将Prometheus文本格式的指标写入w
+func (c *cache) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	c.kv_mu.RLock()
+	kvCount := len(c.kvItems)
+	c.kv_mu.RUnlock()
+	c.hash_mu.RLock()
+	hashCount := len(c.hashItems)
+	c.hash_mu.RUnlock()
+	c.set_mu.RLock()
+	setCount, memberCount := len(c.setItems), 0
+	for _, item := range c.setItems {
+		memberCount += len(item.Object)
+	}
+	c.set_mu.RUnlock()
+	c.stream_mu.RLock()
+	streamCount := len(c.streamItems)
+	c.stream_mu.RUnlock()
+	c.hll_mu.RLock()
+	hllCount := len(c.hllItems)
+	c.hll_mu.RUnlock()
+	c.geo_mu.RLock()
+	geoCount := len(c.geoItems)
+	c.geo_mu.RUnlock()
+	c.bloom_mu.RLock()
+	bloomCount := len(c.bloomItems)
+	c.bloom_mu.RUnlock()
+	c.cuckoo_mu.RLock()
+	cuckooCount := len(c.cuckooItems)
+	c.cuckoo_mu.RUnlock()
+	c.cms_mu.RLock()
+	cmsCount := len(c.cmsItems)
+	c.cms_mu.RUnlock()
+	c.topk_mu.RLock()
+	topKCount := len(c.topKItems)
+	c.topk_mu.RUnlock()
+	c.limit_mu.Lock()
+	limitCount := len(c.limitItems)
+	c.limit_mu.Unlock()
+	c.lock_mu.Lock()
+	lockCount := len(c.lockItems)
+	c.lock_mu.Unlock()
+	c.lease_mu.Lock()
+	semCount, rwLockCount := len(c.semItems), len(c.rwLockItems)
+	c.lease_mu.Unlock()
+
+	writeHeader(bw, "speed_items", "gauge", "Number of keys per store.")
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeKV.String(), kvCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeHash.String(), hashCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeSet.String(), setCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeStream.String(), streamCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeHLL.String(), hllCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeGeo.String(), geoCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeBloom.String(), bloomCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeCuckoo.String(), cuckooCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeCMS.String(), cmsCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeTopK.String(), topKCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeLimit.String(), limitCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeLock.String(), lockCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeSemaphore.String(), semCount)
+	fmt.Fprintf(bw, "speed_items{store=%q} %d\n", TypeRWLock.String(), rwLockCount)
+	writeHeader(bw, "speed_set_members", "gauge", "Number of members across all sets.")
+	fmt.Fprintf(bw, "speed_set_members %d\n", memberCount)
+
+	stats := c.Stats()
+	counters := []struct {
+		name, help string
+		value      func(TypeStats) uint64
+	}{
+		{"speed_hits_total", "Lookups that found a live entry.", func(s TypeStats) uint64 { return s.Hits }},
+		{"speed_misses_total", "Lookups that found no live entry.", func(s TypeStats) uint64 { return s.Misses }},
+		{"speed_sets_total", "Write operations.", func(s TypeStats) uint64 { return s.Sets }},
+		{"speed_deletes_total", "Explicit deletes.", func(s TypeStats) uint64 { return s.Deletes }},
+		{"speed_expirations_total", "Entries removed by TTL expiry.", func(s TypeStats) uint64 { return s.Expirations }},
+		{"speed_callbacks_total", "Delete callbacks invoked.", func(s TypeStats) uint64 { return s.Callbacks }},
+		{"speed_evictions_total", "Entries evicted by the cache.", func(s TypeStats) uint64 { return s.Evictions }},
+	}
+	for _, counter := range counters {
+		writeHeader(bw, counter.name, "counter", counter.help)
+		for t := 0; t < dataTypeCount; t++ {
+			fmt.Fprintf(bw, "%s{type=%q} %d\n", counter.name, DataType(t).String(), counter.value(stats[DataType(t)]))
+		}
+	}
+	writeHeader(bw, "speed_hit_ratio", "gauge", "Hits divided by lookups.")
+	for t := 0; t < dataTypeCount; t++ {
+		fmt.Fprintf(bw, "speed_hit_ratio{type=%q} %s\n", DataType(t).String(), formatFloat(stats[DataType(t)].HitRatio()))
+	}
+
+	writeHeader(bw, "speed_timewheel_pending", "gauge", "Pending timers per time wheel level and slot.")
+	for level, slots := range c.timeWheel.SlotCounts() {
+		for slot, n := range slots {
+			fmt.Fprintf(bw, "speed_timewheel_pending{level=\"%d\",slot=\"%d\"} %d\n", level, slot, n)
+		}
+	}
+	writeHeader(bw, "speed_timewheel_channel_depth", "gauge", "Expired entries waiting in the time wheel channel.")
+	fmt.Fprintf(bw, "speed_timewheel_channel_depth %d\n", len(c.timeWheel.C))
+	writeHeader(bw, "speed_timewheel_channel_capacity", "gauge", "Capacity of the time wheel channel.")
+	fmt.Fprintf(bw, "speed_timewheel_channel_capacity %d\n", cap(c.timeWheel.C))
+	twStats := c.timeWheel.Stats()
+	writeHeader(bw, "speed_timewheel_timers", "gauge", "Total pending timers in the time wheel.")
+	fmt.Fprintf(bw, "speed_timewheel_timers %d\n", c.timeWheel.Pending())
+	writeHeader(bw, "speed_timewheel_backlog", "gauge", "Expired entries waiting for room in the time wheel channel.")
+	fmt.Fprintf(bw, "speed_timewheel_backlog %d\n", twStats.Backlog)
+	writeHeader(bw, "speed_timewheel_spilled", "gauge", "Timer operations waiting in the overflow queue.")
+	fmt.Fprintf(bw, "speed_timewheel_spilled %d\n", twStats.Spilled)
+	writeHeader(bw, "speed_timewheel_dropped_total", "counter", "Timer operations and expirations dropped on overflow.")
+	fmt.Fprintf(bw, "speed_timewheel_dropped_total{kind=\"op\"} %d\n", twStats.DroppedOps)
+	fmt.Fprintf(bw, "speed_timewheel_dropped_total{kind=\"fired\"} %d\n", twStats.DroppedFired)
+
+	writeHeader(bw, "speed_callback_queue_depth", "gauge", "Delete callbacks waiting to run, including the overflow queue.")
+	fmt.Fprintf(bw, "speed_callback_queue_depth %d\n", c.dispatcher.backlog())
+	writeHeader(bw, "speed_callback_dropped_total", "counter", "Delete callbacks dropped on a full queue.")
+	fmt.Fprintf(bw, "speed_callback_dropped_total %d\n", atomic.LoadUint64(&c.dispatcher.dropped))
+
+	writeHeader(bw, "speed_callback_duration_seconds", "histogram", "Delete callback latency.")
+	for t := 0; t < dataTypeCount; t++ {
+		h := &c.callBackCost[t]
+		name := DataType(t).String()
+		var cumulative uint64
+		for i, le := range callBackBuckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			fmt.Fprintf(bw, "speed_callback_duration_seconds_bucket{type=%q,le=%q} %d\n", name, formatFloat(le), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&h.counts[len(callBackBuckets)])
+		fmt.Fprintf(bw, "speed_callback_duration_seconds_bucket{type=%q,le=\"+Inf\"} %d\n", name, cumulative)
+		fmt.Fprintf(bw, "speed_callback_duration_seconds_sum{type=%q} %s\n", name, formatFloat(time.Duration(atomic.LoadUint64(&h.sum)).Seconds()))
+		fmt.Fprintf(bw, "speed_callback_duration_seconds_count{type=%q} %d\n", name, atomic.LoadUint64(&h.count))
+	}
+
+	writeHeader(bw, "speed_snowflake_ids_generated_total", "counter", "Snowflake IDs generated.")
+	fmt.Fprintf(bw, "speed_snowflake_ids_generated_total %d\n", c.snowflake.Generated())
+
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,133 @@
+package speed
+
+import "context"
+
+// Option 缓存配置项
+type Option func(*options)
+
+type options struct {
+	callBackWorkers      int            //回调工作协程数量
+	callBackQueueSize    int            //回调队列长度
+	orderedCallBacks     bool           //同一key的回调按顺序投递
+	callBackOverflow     OverflowPolicy //回调队列满时的处理方式
+	callBackErrorHandler func(error)    //回调panic时的处理函数
+	pubSubBufferSize     int            //每个订阅的消息缓冲大小
+	pubSubPolicy         DeliveryPolicy //订阅缓冲区满时的处理方式
+	hotKeys              int            //热点key统计数量 0为不统计
+	timeWheelOps         int            //时间轮操作channel缓冲大小
+	timeWheelFired       int            //时间轮到期通知channel缓冲大小
+	timeWheelOverflow    OverflowPolicy //时间轮channel满时的处理方式
+	clock                Clock          //时间来源
+	callBacksOnClose     bool           //Close时对未过期的数据触发回调
+	closeHooks           []func(context.Context) error
+}
+
+func defaultOptions() options {
+	return options{
+		callBackWorkers:   1,
+		callBackQueueSize: 1024,
+		callBackOverflow:  SpillOnOverflow,
+		pubSubBufferSize:  256,
+		pubSubPolicy:      DropOnFull,
+		timeWheelOps:      10000,
+		timeWheelFired:    10000,
+		clock:             realClock{},
+	}
+}
+
+// WithCallBackWorkers 设置执行删除回调的工作协程数量 默认1
+func WithCallBackWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.callBackWorkers = n
+		}
+	}
+}
+
+// WithCallBackQueueSize 设置每个回调队列的长度 默认1024，必须大于0，队列满时的处理方式见WithCallBackOverflow
+func WithCallBackQueueSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.callBackQueueSize = n
+		}
+	}
+}
+
+// WithCallBackOverflow 设置回调队列满时的处理方式 默认SpillOnOverflow放入无界的溢出队列
+// DropOnOverflow丢弃并计数；BlockOnOverflow等待，过期处理会被慢回调拖住，回调中写缓存时可能因自己的队列已满而死锁
+func WithCallBackOverflow(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.callBackOverflow = policy
+	}
+}
+
+// WithOrderedCallBacks 同一key的回调固定由同一个工作协程按触发顺序执行
+func WithOrderedCallBacks() Option {
+	return func(o *options) {
+		o.orderedCallBacks = true
+	}
+}
+
+// WithCallBackErrorHandler 设置回调panic时的处理函数 参数为*CallBackPanicError
+func WithCallBackErrorHandler(f func(error)) Option {
+	return func(o *options) {
+		o.callBackErrorHandler = f
+	}
+}
+
+// WithPubSubBuffer 设置每个订阅的消息缓冲大小和缓冲区满时的处理方式 默认256、DropOnFull
+func WithPubSubBuffer(size int, policy DeliveryPolicy) Option {
+	return func(o *options) {
+		if size >= 0 {
+			o.pubSubBufferSize = size
+		}
+		o.pubSubPolicy = policy
+	}
+}
+
+// WithHotKeys 开启热点key统计 记录k-v、hash、集合读写次数最多的k个key，通过HotKeys获取
+func WithHotKeys(k int) Option {
+	return func(o *options) {
+		o.hotKeys = k
+	}
+}
+
+// WithTimeWheelBuffer 设置时间轮添加/删除定时器和到期通知的缓冲大小以及满时的处理方式 默认10000、10000、BlockOnOverflow
+// DropOnOverflow会丢失过期通知，被丢弃的key不会自动删除
+func WithTimeWheelBuffer(ops, fired int, policy OverflowPolicy) Option {
+	return func(o *options) {
+		if ops >= 0 {
+			o.timeWheelOps = ops
+		}
+		if fired >= 0 {
+			o.timeWheelFired = fired
+		}
+		o.timeWheelOverflow = policy
+	}
+}
+
+// WithClock 设置时间来源 默认为系统时间
+// 传入FakeClock后过期、租期、时间轮、调度器以及锁和信号量阻塞等待的租期都只随Advance前进，传给阻塞方法的ctx超时仍使用系统时间
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// WithCallBacksOnClose Close时对还在等待过期、设置了回调的数据以ReasonClosed触发回调 数据本身不删除
+func WithCallBacksOnClose() Option {
+	return func(o *options) {
+		o.callBacksOnClose = true
+	}
+}
+
+// WithCloseHook 添加Close时执行的持久化钩子 在回调执行完之后按添加顺序执行，可以在钩子中导出数据
+func WithCloseHook(f func(ctx context.Context) error) Option {
+	return func(o *options) {
+		if f != nil {
+			o.closeHooks = append(o.closeHooks, f)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package speed
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Message 发布的消息
+type Message struct {
+	Channel string      //消息发布的频道
+	Pattern string      //通过PSubscribe匹配时为对应的模式，否则为空
+	Payload interface{} //消息内容
+}
+
+// Subscription 频道订阅 一个订阅可以同时订阅多个频道和模式
+type Subscription struct {
+	C        <-chan Message //消息通道 Close之后关闭
+	ch       chan Message
+	done     chan struct{}
+	policy   DeliveryPolicy
+	channels map[string]struct{} //受pubSub.mu保护
+	patterns map[string]struct{} //受pubSub.mu保护
+	dropped  uint64
+	once     sync.Once
+	ps       *pubSub
+}
+
+// pubSub 频道和模式的订阅关系
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+	bufSize  int
+	policy   DeliveryPolicy
+}
+
+func newPubSub(o options) *pubSub {
+	return &pubSub{
+		channels: map[string]map[*Subscription]struct{}{},
+		patterns: map[string]map[*Subscription]struct{}{},
+		bufSize:  o.pubSubBufferSize,
+		policy:   o.pubSubPolicy,
+	}
+}
+
+// Publish 向频道发布消息 返回接收到消息的订阅数量
+func (c *cache) Publish(channel string, payload interface{}) int {
+	if c.isClosed() {
+		return 0
+	}
+	ps := c.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	n := 0
+	for sub := range ps.channels[channel] {
+		if sub.deliver(Message{Channel: channel, Payload: payload}) {
+			n++
+		}
+	}
+	for pattern, subs := range ps.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			if sub.deliver(Message{Channel: channel, Pattern: pattern, Payload: payload}) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Subscribe 创建订阅并订阅频道
+func (c *cache) Subscribe(channels ...string) *Subscription {
+	sub := c.pubsub.newSubscription()
+	sub.Subscribe(channels...)
+	return sub
+}
+
+// PSubscribe 创建订阅并按模式订阅频道 模式规则同SubscribeEvents
+func (c *cache) PSubscribe(patterns ...string) *Subscription {
+	sub := c.pubsub.newSubscription()
+	sub.PSubscribe(patterns...)
+	return sub
+}
+
+// PubSubChannels 获取至少有一个订阅者的频道 pattern为空时返回全部
+func (c *cache) PubSubChannels(pattern string) []string {
+	ps := c.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	res := make([]string, 0, len(ps.channels))
+	for channel := range ps.channels {
+		if pattern == "" || matchPattern(pattern, channel) {
+			res = append(res, channel)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// PubSubNumSub 获取频道的订阅数量 不包含模式订阅
+func (c *cache) PubSubNumSub(channel string) int {
+	c.pubsub.mu.RLock()
+	defer c.pubsub.mu.RUnlock()
+	return len(c.pubsub.channels[channel])
+}
+
+func (ps *pubSub) newSubscription() *Subscription {
+	ch := make(chan Message, ps.bufSize)
+	return &Subscription{
+		C:        ch,
+		ch:       ch,
+		done:     make(chan struct{}),
+		policy:   ps.policy,
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+		ps:       ps,
+	}
+}
+
+// Subscribe 追加订阅频道
+func (s *Subscription) Subscribe(channels ...string) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if s.closed() {
+		return
+	}
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+		addSubscriber(s.ps.channels, channel, s)
+	}
+}
+
+// PSubscribe 追加订阅模式
+func (s *Subscription) PSubscribe(patterns ...string) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if s.closed() {
+		return
+	}
+	for _, pattern := range patterns {
+		s.patterns[pattern] = struct{}{}
+		addSubscriber(s.ps.patterns, pattern, s)
+	}
+}
+
+// Unsubscribe 取消订阅频道 channels为空时取消全部频道
+func (s *Subscription) Unsubscribe(channels ...string) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if len(channels) == 0 {
+		for channel := range s.channels {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		delete(s.channels, channel)
+		removeSubscriber(s.ps.channels, channel, s)
+	}
+}
+
+// PUnsubscribe 取消订阅模式 patterns为空时取消全部模式
+func (s *Subscription) PUnsubscribe(patterns ...string) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if len(patterns) == 0 {
+		for pattern := range s.patterns {
+			patterns = append(patterns, pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		delete(s.patterns, pattern)
+		removeSubscriber(s.ps.patterns, pattern, s)
+	}
+}
+
+// Dropped 因缓冲区满被丢弃的消息数量
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close 取消全部订阅并关闭消息通道
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done) //先唤醒阻塞在发送上的Publish，再获取写锁
+		s.Unsubscribe()
+		s.PUnsubscribe()
+		close(s.ch)
+	})
+}
+
+func (s *Subscription) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver 投递消息 返回是否投递成功
+func (s *Subscription) deliver(msg Message) bool {
+	if s.policy == BlockOnFull {
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	case <-s.done:
+		return false
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+func addSubscriber(m map[string]map[*Subscription]struct{}, name string, s *Subscription) {
+	subs, ok := m[name]
+	if !ok {
+		subs = map[*Subscription]struct{}{}
+		m[name] = subs
+	}
+	subs[s] = struct{}{}
+}
+
+func removeSubscriber(m map[string]map[*Subscription]struct{}, name string, s *Subscription) {
+	if subs, ok := m[name]; ok {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(m, name)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package speed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPubSub(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	sub := c.Subscribe("news")
+	psub := c.PSubscribe("news.*")
+	defer psub.Close()
+
+	if n := c.Publish("news", "hello"); n != 1 {
+		t.Fatalf("Publish(news) = %d, want 1", n)
+	}
+	if n := c.Publish("news.sport", "goal"); n != 1 {
+		t.Fatalf("Publish(news.sport) = %d, want 1", n)
+	}
+	if msg := <-sub.C; msg.Channel != "news" || msg.Payload != "hello" || msg.Pattern != "" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if msg := <-psub.C; msg.Channel != "news.sport" || msg.Pattern != "news.*" || msg.Payload != "goal" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if got := c.PubSubChannels(""); !reflect.DeepEqual(got, []string{"news"}) {
+		t.Fatalf("PubSubChannels = %v", got)
+	}
+
+	sub.Unsubscribe("news")
+	if n := c.Publish("news", "bye"); n != 0 {
+		t.Fatalf("Publish after Unsubscribe = %d, want 0", n)
+	}
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("channel not closed after Close")
+	}
+	if c.PubSubNumSub("news") != 0 {
+		t.Fatal("closed subscription still registered")
+	}
+}
+
+func TestPubSubDrop(t *testing.T) {
+	c, err := New(WithPubSubBuffer(1, DropOnFull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	sub := c.Subscribe("news")
+	defer sub.Close()
+	c.Publish("news", 1)
+	if n := c.Publish("news", 2); n != 0 || sub.Dropped() != 1 {
+		t.Fatalf("Publish = %d, dropped = %d", n, sub.Dropped())
+	}
+}
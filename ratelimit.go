@@ -0,0 +1,309 @@
+package speed
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// LimitAlgorithm 限流算法
+type LimitAlgorithm int
+
+const (
+	TokenBucket   LimitAlgorithm = iota //令牌桶 允许Burst大小的突发
+	FixedWindow                         //固定窗口计数
+	SlidingLog                          //滑动日志 精确但每个请求占用一条记录
+	SlidingWindow                       //滑动窗口 按上一个窗口的计数加权估算
+	GCRA                                //通用信元速率算法 与令牌桶等价，只保存一个时间
+)
+
+func (a LimitAlgorithm) String() string {
+	switch a {
+	case TokenBucket:
+		return "token_bucket"
+	case FixedWindow:
+		return "fixed_window"
+	case SlidingLog:
+		return "sliding_log"
+	case SlidingWindow:
+		return "sliding_window"
+	case GCRA:
+		return "gcra"
+	}
+	return "unknown"
+}
+
+// ErrInvalidLimit 限流参数错误
+var ErrInvalidLimit = errors.New("rate limit requires a known algorithm, positive rate and period")
+
+// Limit 每Period允许Rate次请求 Burst为令牌桶、GCRA允许的突发数量，为0时等于Rate
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// LimitResult 限流结果
+type LimitResult struct {
+	Allowed    bool
+	Remaining  int           //剩余可用次数
+	RetryAfter time.Duration //被拒绝时多久后可以重试，请求数超过上限永远不会被允许时为-1
+	ResetAfter time.Duration //多久后恢复到初始状态
+}
+
+// limitItem 单个key的限流状态 时间均为纳秒
+type limitItem struct {
+	Key        string
+	alg        LimitAlgorithm
+	tokens     float64 //令牌桶 剩余令牌
+	last       int64   //令牌桶 上次补充时间；固定、滑动窗口 当前窗口开始时间；GCRA 理论到达时间
+	count      int     //窗口计数
+	prev       int     //滑动窗口 上一个窗口的计数
+	log        []int64 //滑动日志 窗口内请求时间
+	Expiration int64   //恢复到初始状态的时间
+}
+
+// RateLimiter 限流器 同一个key的每次调用在锁内原子完成
+type RateLimiter struct {
+	c     *cache
+	alg   LimitAlgorithm
+	limit Limit
+}
+
+// NewRateLimiter 创建限流器 不同限流器使用相同的key时共享状态，算法不同时状态会被重置
+func (c *cache) NewRateLimiter(alg LimitAlgorithm, limit Limit) (*RateLimiter, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	if alg < TokenBucket || alg > GCRA || limit.Rate <= 0 || limit.Period <= 0 || limit.Burst < 0 {
+		return nil, ErrInvalidLimit
+	}
+	if limit.Burst == 0 {
+		limit.Burst = limit.Rate
+	}
+	return &RateLimiter{c: c, alg: alg, limit: limit}, nil
+}
+
+// Allow 请求一次
+func (l *RateLimiter) Allow(key string) LimitResult {
+	return l.AllowN(key, 1)
+}
+
+// AllowN 请求n次 被拒绝时不消耗次数，n小于1时直接拒绝
+func (l *RateLimiter) AllowN(key string, n int) LimitResult {
+	c := l.c
+	if c.isClosed() || n <= 0 {
+		return LimitResult{RetryAfter: -1}
+	}
+	now := c.clock.Now().UnixNano()
+	c.limit_mu.Lock()
+	item, ok := c.limitItems[key]
+	if !ok || item.alg != l.alg || item.Expiration <= now {
+		item = l.reset(item, key, now)
+	}
+	var res LimitResult
+	switch l.alg {
+	case TokenBucket:
+		res = l.tokenBucket(item, n, now)
+	case FixedWindow:
+		res = l.fixedWindow(item, n, now)
+	case SlidingLog:
+		res = l.slidingLog(item, n, now)
+	case SlidingWindow:
+		res = l.slidingWindow(item, n, now)
+	case GCRA:
+		res = l.gcra(item, n, now)
+	}
+	item.Expiration = now + int64(res.ResetAfter)
+	if !ok {
+		c.limitItems[key] = item
+	}
+	c.limit_mu.Unlock()
+	c.stats.set(TypeLimit)
+	if !ok {
+		c.timeWheel.AddTimer(leaseTimerDelay(res.ResetAfter), timerKey{TypeLimit, key}, item)
+	}
+	return res
+}
+
+// Reset 清除key的限流状态
+func (l *RateLimiter) Reset(key string) bool {
+	return l.c.ResetLimit(key)
+}
+
+// reset 重置为初始状态 已有的item原地复用，保持与时间轮中的定时器对应
+func (l *RateLimiter) reset(item *limitItem, key string, now int64) *limitItem {
+	if item == nil {
+		item = &limitItem{Key: key}
+	}
+	item.alg = l.alg
+	item.tokens = float64(l.limit.Burst)
+	item.last = now
+	item.count, item.prev = 0, 0
+	item.log = nil
+	if l.alg == FixedWindow || l.alg == SlidingWindow {
+		item.last = now - now%int64(l.limit.Period)
+	}
+	return item
+}
+
+func (l *RateLimiter) tokenBucket(item *limitItem, n int, now int64) LimitResult {
+	perToken := float64(l.limit.Period) / float64(l.limit.Rate)
+	burst := float64(l.limit.Burst)
+	item.tokens = math.Min(burst, item.tokens+float64(now-item.last)/perToken)
+	item.last = now
+	res := LimitResult{Allowed: float64(n) <= item.tokens}
+	if res.Allowed {
+		item.tokens -= float64(n)
+	} else if n > l.limit.Burst {
+		res.RetryAfter = -1
+	} else {
+		res.RetryAfter = time.Duration((float64(n) - item.tokens) * perToken)
+	}
+	res.Remaining = int(item.tokens)
+	res.ResetAfter = time.Duration((burst - item.tokens) * perToken)
+	return res
+}
+
+func (l *RateLimiter) fixedWindow(item *limitItem, n int, now int64) LimitResult {
+	period := int64(l.limit.Period)
+	if start := now - now%period; start != item.last {
+		item.last, item.count = start, 0
+	}
+	windowEnd := time.Duration(item.last + period - now)
+	res := LimitResult{Allowed: item.count+n <= l.limit.Rate}
+	if res.Allowed {
+		item.count += n
+	} else if n > l.limit.Rate {
+		res.RetryAfter = -1
+	} else {
+		res.RetryAfter = windowEnd
+	}
+	res.Remaining = l.limit.Rate - item.count
+	res.ResetAfter = windowEnd
+	return res
+}
+
+func (l *RateLimiter) slidingLog(item *limitItem, n int, now int64) LimitResult {
+	period := int64(l.limit.Period)
+	i := 0
+	for i < len(item.log) && item.log[i] <= now-period {
+		i++
+	}
+	item.log = item.log[i:]
+	res := LimitResult{Allowed: len(item.log)+n <= l.limit.Rate}
+	if res.Allowed {
+		for j := 0; j < n; j++ {
+			item.log = append(item.log, now)
+		}
+	} else if n > l.limit.Rate {
+		res.RetryAfter = -1
+	} else {
+		//等待最早的若干条记录移出窗口
+		res.RetryAfter = time.Duration(item.log[len(item.log)+n-l.limit.Rate-1] + period - now)
+	}
+	res.Remaining = l.limit.Rate - len(item.log)
+	if len(item.log) > 0 {
+		res.ResetAfter = time.Duration(item.log[len(item.log)-1] + period - now)
+	}
+	return res
+}
+
+func (l *RateLimiter) slidingWindow(item *limitItem, n int, now int64) LimitResult {
+	period := int64(l.limit.Period)
+	start := now - now%period
+	switch {
+	case start == item.last+period:
+		item.prev, item.count = item.count, 0
+	case start != item.last:
+		item.prev, item.count = 0, 0
+	}
+	item.last = start
+	elapsed := now - start
+	weight := float64(period-elapsed) / float64(period)
+	used := int(math.Ceil(float64(item.prev)*weight)) + item.count
+	res := LimitResult{Allowed: used+n <= l.limit.Rate}
+	if res.Allowed {
+		item.count += n
+		used += n
+	} else if n > l.limit.Rate {
+		res.RetryAfter = -1
+	} else if item.count+n <= l.limit.Rate {
+		//本窗口内等待上一个窗口的权重降低
+		w := float64(l.limit.Rate-item.count-n) / float64(item.prev)
+		res.RetryAfter = time.Duration(float64(period)*(1-w)) - time.Duration(elapsed)
+	} else {
+		//等到下一个窗口，本窗口的计数成为上一个窗口的计数
+		w := float64(l.limit.Rate-n) / float64(item.count)
+		res.RetryAfter = time.Duration(period-elapsed) + time.Duration(float64(period)*(1-w))
+	}
+	res.Remaining = l.limit.Rate - used
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	res.ResetAfter = time.Duration(period - elapsed)
+	if item.count > 0 {
+		res.ResetAfter += time.Duration(period)
+	}
+	return res
+}
+
+func (l *RateLimiter) gcra(item *limitItem, n int, now int64) LimitResult {
+	interval := float64(l.limit.Period) / float64(l.limit.Rate)
+	burstOffset := int64(interval * float64(l.limit.Burst))
+	tat := item.last
+	if tat < now {
+		tat = now
+	}
+	newTat := tat + int64(interval*float64(n))
+	diff := now - (newTat - burstOffset)
+	res := LimitResult{Allowed: diff >= 0}
+	if res.Allowed {
+		tat = newTat
+		item.last = tat
+	} else if n > l.limit.Burst {
+		res.RetryAfter = -1
+	} else {
+		res.RetryAfter = time.Duration(-diff)
+	}
+	res.Remaining = int(float64(now-(tat-burstOffset)) / interval)
+	res.ResetAfter = time.Duration(tat - now)
+	return res
+}
+
+// ResetLimit 清除key的限流状态
+func (c *cache) ResetLimit(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.limit_mu.Lock()
+	_, ok := c.limitItems[key]
+	if ok {
+		delete(c.limitItems, key)
+	}
+	c.limit_mu.Unlock()
+	//不删除定时器 到期时发现状态已被替换会直接忽略
+	if ok {
+		c.stats.delete(TypeLimit)
+	}
+	return ok
+}
+
+// limitExpire 时间轮到期 状态已恢复则删除，否则按剩余时间重新加入时间轮
+func (c *cache) limitExpire(item *limitItem) {
+	now := c.clock.Now().UnixNano()
+	c.limit_mu.Lock()
+	cur, ok := c.limitItems[item.Key]
+	if !ok || cur != item {
+		c.limit_mu.Unlock()
+		return
+	}
+	if remain := time.Duration(item.Expiration - now); remain > 0 {
+		c.limit_mu.Unlock()
+		c.timeWheel.AddTimer(leaseTimerDelay(remain), timerKey{TypeLimit, item.Key}, item)
+		return
+	}
+	delete(c.limitItems, item.Key)
+	c.limit_mu.Unlock()
+	c.stats.expire(TypeLimit)
+}
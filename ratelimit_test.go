@@ -0,0 +1,135 @@
+package speed
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiters(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	for _, alg := range []LimitAlgorithm{TokenBucket, FixedWindow, SlidingLog, SlidingWindow, GCRA} {
+		l, err := c.NewRateLimiter(alg, Limit{Rate: 5, Period: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := "user:" + alg.String()
+		for i := 0; i < 5; i++ {
+			res := l.Allow(key)
+			if !res.Allowed || res.Remaining != 4-i {
+				t.Fatalf("%s: request %d = %+v", alg, i, res)
+			}
+		}
+		res := l.Allow(key)
+		if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 || res.RetryAfter > 2*time.Minute || res.ResetAfter <= 0 {
+			t.Fatalf("%s: over limit = %+v", alg, res)
+		}
+		if res := l.AllowN(key, 6); res.Allowed || res.RetryAfter != -1 {
+			t.Fatalf("%s: AllowN over burst = %+v", alg, res)
+		}
+		if !l.Reset(key) || !l.Allow(key).Allowed {
+			t.Fatalf("%s: Reset did not clear the state", alg)
+		}
+	}
+	if _, err := c.NewRateLimiter(GCRA, Limit{Rate: 0, Period: time.Second}); err != ErrInvalidLimit {
+		t.Fatalf("err = %v, want ErrInvalidLimit", err)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	for _, alg := range []LimitAlgorithm{TokenBucket, FixedWindow, SlidingLog, SlidingWindow, GCRA} {
+		l, _ := c.NewRateLimiter(alg, Limit{Rate: 2, Period: 50 * time.Millisecond})
+		l.AllowN("refill", 2)
+		res := l.Allow("refill")
+		if res.Allowed {
+			t.Fatalf("%s: allowed over limit", alg)
+		}
+		time.Sleep(res.RetryAfter + 5*time.Millisecond)
+		if res := l.Allow("refill"); !res.Allowed {
+			t.Fatalf("%s: not allowed after RetryAfter: %+v", alg, res)
+		}
+		c.ResetLimit("refill")
+	}
+}
+
+func TestRateLimiterExpire(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	l, _ := c.NewRateLimiter(TokenBucket, Limit{Rate: 10, Period: time.Second})
+	l.Allow("ip:1")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats()[TypeLimit].Expirations == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("limiter state was not cleaned up by the time wheel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.ResetLimit("ip:1") {
+		t.Fatal("expired state still present")
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	l, _ := c.NewRateLimiter(SlidingLog, Limit{Rate: 50, Period: time.Minute})
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Allow("api").Allowed {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 50 {
+		t.Fatalf("allowed %d requests, want 50", allowed)
+	}
+}
+
+func TestRateLimiterInvalidN(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	for _, alg := range []LimitAlgorithm{TokenBucket, FixedWindow, SlidingLog, SlidingWindow, GCRA} {
+		l, err := c.NewRateLimiter(alg, Limit{Rate: 2, Period: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := "user:" + alg.String()
+		for _, n := range []int{0, -1, -100} {
+			if res := l.AllowN(key, n); res.Allowed || res.RetryAfter != -1 {
+				t.Fatalf("%s: AllowN(%d) = %+v", alg, n, res)
+			}
+		}
+		for i := 0; i < 2; i++ { //负数请求不能多放行
+			if !l.Allow(key).Allowed {
+				t.Fatalf("%s: request %d denied", alg, i)
+			}
+		}
+		if l.Allow(key).Allowed {
+			t.Fatalf("%s: allowed more than the limit", alg)
+		}
+	}
+}
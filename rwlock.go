@@ -0,0 +1,151 @@
+package speed
+
+import (
+	"context"
+	"time"
+)
+
+// RWLocker 读写锁 多个读锁可以同时持有，写锁独占；有写锁等待时不再发放新的读锁
+type RWLocker struct {
+	c   *cache
+	ttl time.Duration
+}
+
+// RWLock 已获取的读锁或写锁
+type RWLock struct {
+	c     *cache
+	Key   string
+	Token ID
+	Write bool
+}
+
+// NewRWLocker 创建读写锁 ttl为锁的租期，0为永不过期
+func (c *cache) NewRWLocker(ttl time.Duration) *RWLocker {
+	return &RWLocker{c: c, ttl: ttl}
+}
+
+func (rw *RWLocker) try(key string, write, waiting bool) (*RWLock, lockWait) {
+	token, w, ok := rw.c.leaseAcquire(TypeRWLock, key, rw.ttl, write, func(l *leaseItem) bool {
+		if !write {
+			return l.writer == 0 && l.writersWaiting == 0
+		}
+		if len(l.holders) > 0 {
+			return false
+		}
+		if waiting && l.writersWaiting > 0 {
+			l.writersWaiting--
+		}
+		return true
+	})
+	if !ok {
+		return nil, w
+	}
+	return &RWLock{c: rw.c, Key: key, Token: token, Write: write}, w
+}
+
+// TryRLock 尝试获取读锁 有写锁或写锁等待时返回ErrLockHeld
+func (rw *RWLocker) TryRLock(key string) (*RWLock, error) {
+	if rw.c.isClosed() {
+		return nil, ErrClosed
+	}
+	l, _ := rw.try(key, false, false)
+	if l == nil {
+		return nil, ErrLockHeld
+	}
+	return l, nil
+}
+
+// TryLock 尝试获取写锁 有任何持有者时返回ErrLockHeld
+func (rw *RWLocker) TryLock(key string) (*RWLock, error) {
+	if rw.c.isClosed() {
+		return nil, ErrClosed
+	}
+	l, _ := rw.try(key, true, false)
+	if l == nil {
+		return nil, ErrLockHeld
+	}
+	return l, nil
+}
+
+// RLock 阻塞获取读锁 直到成功或ctx结束
+func (rw *RWLocker) RLock(ctx context.Context, key string) (*RWLock, error) {
+	for {
+		if rw.c.isClosed() {
+			return nil, ErrClosed
+		}
+		l, w := rw.try(key, false, false)
+		if l != nil {
+			return l, nil
+		}
+		if err := rw.c.waitRelease(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Lock 阻塞获取写锁 直到成功或ctx结束，等待期间阻止新的读锁
+func (rw *RWLocker) Lock(ctx context.Context, key string) (*RWLock, error) {
+	waiting := false
+	defer func() {
+		if waiting {
+			rw.setWaiting(key, -1)
+		}
+	}()
+	for {
+		if rw.c.isClosed() {
+			return nil, ErrClosed
+		}
+		l, w := rw.try(key, true, waiting)
+		if l != nil {
+			waiting = false
+			return l, nil
+		}
+		if !waiting {
+			waiting = true
+			rw.setWaiting(key, 1)
+		}
+		if err := rw.c.waitRelease(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// setWaiting 调整等待中的写锁数量 减少时唤醒等待的读锁
+func (rw *RWLocker) setWaiting(key string, delta int) {
+	rw.c.lease_mu.Lock()
+	defer rw.c.lease_mu.Unlock()
+	l, ok := rw.c.rwLockItems[key]
+	if !ok {
+		if delta < 0 {
+			return //已被Flush清空
+		}
+		l = newLeaseItem(TypeRWLock, key)
+		rw.c.rwLockItems[key] = l
+	}
+	l.writersWaiting += delta
+	if l.writersWaiting < 0 {
+		l.writersWaiting = 0
+	}
+	if delta < 0 {
+		l.signal()
+		if len(l.holders) == 0 && l.writersWaiting == 0 && !l.scheduled {
+			delete(rw.c.rwLockItems, key)
+		}
+	}
+}
+
+// Unlock 释放锁 锁已过期时返回ErrLockNotHeld
+func (l *RWLock) Unlock() error {
+	if !l.c.leaseRelease(TypeRWLock, l.Key, l.Token) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend 续期 租期从现在开始重新计算为ttl
+func (l *RWLock) Extend(ttl time.Duration) error {
+	if !l.c.leaseExtend(TypeRWLock, l.Key, l.Token, ttl) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package speed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRWLocker(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	rw := c.NewRWLocker(time.Minute)
+	r1, err1 := rw.TryRLock("config")
+	r2, err2 := rw.TryRLock("config")
+	if err1 != nil || err2 != nil {
+		t.Fatal("readers should share the lock")
+	}
+	if _, err := rw.TryLock("config"); err != ErrLockHeld {
+		t.Fatalf("err = %v, want ErrLockHeld", err)
+	}
+
+	writer := make(chan *RWLock)
+	go func() {
+		w, err := rw.Lock(context.Background(), "config")
+		if err != nil {
+			t.Error(err)
+		}
+		writer <- w
+	}()
+	waitFor(t, func() bool {
+		c.lease_mu.Lock()
+		defer c.lease_mu.Unlock()
+		return c.rwLockItems["config"].writersWaiting == 1
+	})
+	if _, err := rw.TryRLock("config"); err != ErrLockHeld {
+		t.Fatal("new reader acquired while a writer is waiting")
+	}
+	r1.Unlock()
+	r2.Unlock()
+	var w *RWLock
+	select {
+	case w = <-writer:
+	case <-time.After(time.Second):
+		t.Fatal("writer was not woken")
+	}
+	if !w.Write {
+		t.Fatal("expected a write lock")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rw.RLock(ctx, "config"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if err := w.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Unlock(); err != ErrLockNotHeld {
+		t.Fatalf("err = %v, want ErrLockNotHeld", err)
+	}
+	r, err := rw.RLock(context.Background(), "config")
+	if err != nil || r.Write {
+		t.Fatalf("RLock = %+v, %v", r, err)
+	}
+}
+
+func TestRWLockerWriterTimeout(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	rw := c.NewRWLocker(0)
+	rw.TryRLock("k")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rw.Lock(ctx, "k"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if _, err := rw.TryRLock("k"); err != nil {
+		t.Fatal("readers still blocked after the writer gave up")
+	}
+}
@@ -0,0 +1,246 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrScheduleExists   = errors.New("schedule already exists")
+	ErrSchedulerStopped = errors.New("scheduler stopped")
+)
+
+// ScheduleEntry 定时任务快照
+type ScheduleEntry struct {
+	Name      string
+	Spec      string
+	Next      time.Time //下次执行时间 暂停时为零值
+	Prev      time.Time //上次执行时间
+	Paused    bool
+	Running   bool
+	Runs      uint64 //执行次数
+	Skipped   uint64 //上次执行未结束而跳过的次数
+	LastError error  //最近一次panic
+}
+
+// scheduleEntry 定时任务 字段由Scheduler.mu保护
+type scheduleEntry struct {
+	ScheduleEntry
+	schedule Schedule
+	fn       func(ctx context.Context)
+	gen      uint64 //每次重新安排时递增，旧的定时器到期后直接忽略
+}
+
+// scheduleTick 时间轮中的定时任务到期通知
+type scheduleTick struct {
+	s    *Scheduler
+	name string
+	gen  uint64
+}
+
+// scheduleTimerKey 定时任务在时间轮中的标识 包含gen，暂停后立即恢复时删除的不会是新的定时器
+type scheduleTimerKey struct {
+	s    *Scheduler
+	name string
+	gen  uint64
+}
+
+// Scheduler 定时任务调度器 按cron表达式或@every间隔执行，上次执行未结束时跳过本次
+// 时间轮精度为秒，定时器提前一秒到期后用缓存时钟的AfterFunc等待剩余时间
+type Scheduler struct {
+	c       *cache
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+}
+
+// NewScheduler 创建调度器 缓存停止或调用Stop后不再执行
+func (c *cache) NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(c.ctx)
+	return &Scheduler{c: c, ctx: ctx, cancel: cancel, entries: map[string]*scheduleEntry{}}
+}
+
+// Add 添加定时任务 spec为cron表达式(支持秒)或@every间隔，见ParseCron
+func (s *Scheduler) Add(name, spec string, fn func(ctx context.Context)) error {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	if s.ctx.Err() != nil {
+		return ErrSchedulerStopped
+	}
+	s.mu.Lock()
+	if _, ok := s.entries[name]; ok {
+		s.mu.Unlock()
+		return ErrScheduleExists
+	}
+	e := &scheduleEntry{ScheduleEntry: ScheduleEntry{Name: name, Spec: spec}, schedule: schedule, fn: fn}
+	s.entries[name] = e
+	tick, d := s.reschedule(e, s.c.clock.Now())
+	s.mu.Unlock()
+	s.arm(tick, d)
+	return nil
+}
+
+// Remove 删除定时任务 执行中的任务不会被中断
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	var key scheduleTimerKey
+	if ok {
+		key = scheduleTimerKey{s, name, e.gen}
+		e.gen++
+		delete(s.entries, name)
+	}
+	s.mu.Unlock()
+	if ok {
+		s.c.timeWheel.RemoveTimer(key)
+	}
+	return ok
+}
+
+// Pause 暂停定时任务
+func (s *Scheduler) Pause(name string) bool {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	if !ok || e.Paused {
+		s.mu.Unlock()
+		return false
+	}
+	e.Paused = true
+	e.Next = time.Time{}
+	key := scheduleTimerKey{s, name, e.gen}
+	e.gen++
+	s.mu.Unlock()
+	s.c.timeWheel.RemoveTimer(key)
+	return true
+}
+
+// Resume 恢复定时任务 从当前时间重新计算下次执行时间
+func (s *Scheduler) Resume(name string) bool {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	if !ok || !e.Paused {
+		s.mu.Unlock()
+		return false
+	}
+	e.Paused = false
+	tick, d := s.reschedule(e, s.c.clock.Now())
+	s.mu.Unlock()
+	s.arm(tick, d)
+	return true
+}
+
+// Next 定时任务下次执行时间 暂停时返回零值
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.Next, true
+}
+
+// Entries 所有定时任务 按下次执行时间排序，暂停的排在最后
+func (s *Scheduler) Entries() []ScheduleEntry {
+	s.mu.Lock()
+	res := make([]ScheduleEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		res = append(res, e.ScheduleEntry)
+	}
+	s.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Next.IsZero() != res[j].Next.IsZero() {
+			return !res[i].Next.IsZero()
+		}
+		if !res[i].Next.Equal(res[j].Next) {
+			return res[i].Next.Before(res[j].Next)
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Stop 停止调度 等待执行中的任务结束
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// reschedule 计算下次执行时间并生成新的到期通知 调用方需持有mu
+func (s *Scheduler) reschedule(e *scheduleEntry, now time.Time) (*scheduleTick, time.Duration) {
+	e.gen++
+	e.Next = e.schedule.Next(now)
+	if e.Next.IsZero() {
+		return nil, 0
+	}
+	return &scheduleTick{s: s, name: e.Name, gen: e.gen}, e.Next.Sub(now)
+}
+
+// arm 剩余时间超过两秒时加入时间轮并提前一秒到期，否则直接用缓存时钟的AfterFunc
+func (s *Scheduler) arm(tick *scheduleTick, d time.Duration) {
+	if tick == nil || s.ctx.Err() != nil {
+		return
+	}
+	if d >= 2*time.Second {
+		s.c.timeWheel.AddTimer(d-time.Second, scheduleTimerKey{s, tick.name, tick.gen}, tick)
+		return
+	}
+	s.c.clock.AfterFunc(d, func() { s.fire(tick) })
+}
+
+// fire 到期通知 由时间轮协程或AfterFunc调用，不能阻塞
+func (s *Scheduler) fire(tick *scheduleTick) {
+	now := s.c.clock.Now()
+	s.mu.Lock()
+	e, ok := s.entries[tick.name]
+	if !ok || e.gen != tick.gen || e.Paused || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	if d := e.Next.Sub(now); d > 0 { //提前到期 等待剩余时间
+		s.mu.Unlock()
+		s.arm(tick, d)
+		return
+	}
+	e.Prev = e.Next
+	if e.Running {
+		e.Skipped++
+	} else {
+		e.Running = true
+		e.Runs++
+		s.wg.Add(1)
+		go s.run(e)
+	}
+	next, d := s.reschedule(e, now)
+	s.mu.Unlock()
+	s.arm(next, d)
+}
+
+func (s *Scheduler) run(e *scheduleEntry) {
+	defer s.wg.Done()
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("schedule %q panic: %v", e.Name, r)
+			}
+		}()
+		e.fn(s.ctx)
+	}()
+	s.mu.Lock()
+	e.Running = false
+	if err != nil {
+		e.LastError = err
+	}
+	s.mu.Unlock()
+}
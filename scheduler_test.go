@@ -0,0 +1,131 @@
+package speed
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	s := c.NewScheduler()
+	defer s.Stop()
+	var runs int32
+	if err := s.Add("warm", "@every 20ms", func(ctx context.Context) { atomic.AddInt32(&runs, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("warm", "@every 1s", func(ctx context.Context) {}); err != ErrScheduleExists {
+		t.Fatalf("err = %v, want ErrScheduleExists", err)
+	}
+	if err := s.Add("bad", "* * *", func(ctx context.Context) {}); err == nil {
+		t.Fatal("invalid spec accepted")
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&runs) >= 3 })
+
+	if !s.Pause("warm") || s.Pause("warm") {
+		t.Fatal("Pause should succeed once")
+	}
+	if next, _ := s.Next("warm"); !next.IsZero() {
+		t.Fatal("paused schedule has a next run")
+	}
+	time.Sleep(30 * time.Millisecond) //等待暂停前已触发的执行结束
+	paused := atomic.LoadInt32(&runs)
+	time.Sleep(60 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != paused {
+		t.Fatal("paused schedule kept running")
+	}
+	if !s.Resume("warm") {
+		t.Fatal("Resume failed")
+	}
+	if next, ok := s.Next("warm"); !ok || next.Before(time.Now()) {
+		t.Fatalf("Next after resume = %v", next)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&runs) > paused })
+	if !s.Remove("warm") || s.Remove("warm") {
+		t.Fatal("Remove should succeed once")
+	}
+	if len(s.Entries()) != 0 {
+		t.Fatal("entries left after Remove")
+	}
+}
+
+func TestSchedulerSkipIfRunning(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	s := c.NewScheduler()
+	var running, overlap int32
+	s.Add("slow", "@every 10ms", func(ctx context.Context) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlap, 1)
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	waitFor(t, func() bool {
+		e := s.Entries()
+		return len(e) == 1 && e[0].Runs >= 2 && e[0].Skipped >= 2
+	})
+	s.Stop()
+	if overlap != 0 {
+		t.Fatal("schedule ran while the previous run was still running")
+	}
+}
+
+func TestSchedulerTimeWheel(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	s := c.NewScheduler()
+	defer s.Stop()
+	fired := make(chan time.Time, 1)
+	s.Add("cron", "@every 2s", func(ctx context.Context) {
+		select {
+		case fired <- time.Now():
+		default:
+		}
+	})
+	next, _ := s.Next("cron")
+	select {
+	case at := <-fired:
+		if d := at.Sub(next); d < 0 || d > 100*time.Millisecond {
+			t.Fatalf("fired %v after the scheduled time", d)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("schedule did not fire")
+	}
+}
+
+func TestSchedulerFakeClock(t *testing.T) {
+	c, clock := newFakeCache(t)
+	s := c.NewScheduler()
+	defer s.Stop()
+	var runs int32
+	s.Add("report", "@every 10s", func(ctx context.Context) { atomic.AddInt32(&runs, 1) })
+	if next, _ := s.Next("report"); !next.Equal(clock.Now().Add(10 * time.Second)) {
+		t.Fatalf("Next = %v, want 10s after the fake clock", next)
+	}
+	waitFor(t, func() bool {
+		clock.Advance(time.Second)
+		return atomic.LoadInt32(&runs) >= 1
+	})
+	if e := s.Entries(); e[0].Prev.After(clock.Now()) || e[0].Next.Sub(e[0].Prev) != 10*time.Second {
+		t.Fatalf("entry = %+v", e[0])
+	}
+	waitFor(t, func() bool { return c.timeWheel.Pending() == 1 })
+	s.Pause("report")
+	waitFor(t, func() bool { return c.timeWheel.Pending() == 0 })
+	s.Resume("report")
+	waitFor(t, func() bool { return c.timeWheel.Pending() == 1 })
+	s.Remove("report")
+	waitFor(t, func() bool { return c.timeWheel.Pending() == 0 })
+}
@@ -0,0 +1,284 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoPermits      = errors.New("no permits available")
+	ErrPermitNotHeld  = errors.New("permit is not held by this owner")
+	ErrInvalidPermits = errors.New("semaphore requires a positive number of permits")
+)
+
+// leaseItem 信号量、读写锁的持有者集合 时间为纳秒
+type leaseItem struct {
+	Key            string
+	typ            DataType
+	holders        map[ID]int64 //持有者标识 -> 过期时间，0为永不过期
+	writer         ID           //读写锁 写锁持有者，0为没有写锁
+	writersWaiting int          //读写锁 等待中的写锁数量，大于0时不再发放读锁
+	changed        chan struct{}
+	scheduled      bool //是否已加入时间轮
+}
+
+func newLeaseItem(typ DataType, key string) *leaseItem {
+	return &leaseItem{Key: key, typ: typ, holders: map[ID]int64{}, changed: make(chan struct{})}
+}
+
+// signal 唤醒所有等待者
+func (l *leaseItem) signal() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// prune 删除过期的持有者 返回删除数量
+func (l *leaseItem) prune(now int64) int {
+	n := 0
+	for token, exp := range l.holders {
+		if exp > 0 && exp <= now {
+			delete(l.holders, token)
+			if token == l.writer {
+				l.writer = 0
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		l.signal()
+	}
+	return n
+}
+
+// earliest 最早过期的持有者的剩余时间 没有会过期的持有者时返回0
+func (l *leaseItem) earliest(now int64) time.Duration {
+	var min int64
+	for _, exp := range l.holders {
+		if exp > 0 && (min == 0 || exp < min) {
+			min = exp
+		}
+	}
+	if min == 0 {
+		return 0
+	}
+	return time.Duration(min - now)
+}
+
+// wait 获取失败时的等待条件
+func (l *leaseItem) wait(now int64) lockWait {
+	return lockWait{released: l.changed, timeout: l.earliest(now)}
+}
+
+func (c *cache) leaseItems(typ DataType) map[string]*leaseItem {
+	if typ == TypeRWLock {
+		return c.rwLockItems
+	}
+	return c.semItems
+}
+
+// leaseAcquire 在lease_mu内执行try 成功时添加持有者并返回标识，write为true时同时记为写锁持有者；失败时返回等待条件
+func (c *cache) leaseAcquire(typ DataType, key string, ttl time.Duration, write bool, try func(l *leaseItem) bool) (ID, lockWait, bool) {
+	now := c.clock.Now().UnixNano()
+	c.lease_mu.Lock()
+	items := c.leaseItems(typ)
+	l, ok := items[key]
+	if !ok {
+		l = newLeaseItem(typ, key)
+		items[key] = l
+	}
+	expired := l.prune(now)
+	if !try(l) {
+		w := l.wait(now)
+		c.lease_mu.Unlock()
+		c.stats.addExpire(typ, expired)
+		return 0, w, false
+	}
+	token := c.snowflake.Generate()
+	l.holders[token] = 0
+	if write {
+		l.writer = token
+	}
+	schedule := false
+	if ttl > 0 {
+		l.holders[token] = now + int64(ttl)
+		schedule = !l.scheduled
+		l.scheduled = true
+	}
+	c.lease_mu.Unlock()
+	c.stats.addExpire(typ, expired)
+	c.stats.set(typ)
+	if schedule {
+		c.timeWheel.AddTimer(leaseTimerDelay(ttl), timerKey{typ, key}, l)
+	}
+	return token, lockWait{}, true
+}
+
+// leaseRelease 释放持有者 持有者不存在或已过期时返回false
+func (c *cache) leaseRelease(typ DataType, key string, token ID) bool {
+	now := c.clock.Now().UnixNano()
+	c.lease_mu.Lock()
+	items := c.leaseItems(typ)
+	l, ok := items[key]
+	if ok {
+		exp, held := l.holders[token]
+		ok = held && (exp == 0 || exp > now)
+	}
+	if ok {
+		delete(l.holders, token)
+		if token == l.writer {
+			l.writer = 0
+		}
+		l.signal()
+		if len(l.holders) == 0 && l.writersWaiting == 0 && !l.scheduled {
+			delete(items, key)
+		}
+	}
+	c.lease_mu.Unlock()
+	if ok {
+		c.stats.delete(typ)
+	}
+	return ok
+}
+
+// leaseExtend 把持有者的租期重置为ttl ttl为0表示永不过期
+func (c *cache) leaseExtend(typ DataType, key string, token ID, ttl time.Duration) bool {
+	now := c.clock.Now().UnixNano()
+	c.lease_mu.Lock()
+	l, ok := c.leaseItems(typ)[key]
+	if ok {
+		exp, held := l.holders[token]
+		ok = held && (exp == 0 || exp > now)
+	}
+	schedule := false
+	if ok {
+		l.holders[token] = 0
+		if ttl > 0 {
+			l.holders[token] = now + int64(ttl)
+			schedule = !l.scheduled
+			l.scheduled = true
+		}
+	}
+	c.lease_mu.Unlock()
+	if schedule {
+		c.timeWheel.AddTimer(leaseTimerDelay(ttl), timerKey{typ, key}, l)
+	}
+	return ok
+}
+
+// leaseExpire 时间轮到期 删除过期的持有者，还有会过期的持有者时按最早的过期时间重新加入时间轮
+func (c *cache) leaseExpire(l *leaseItem) {
+	now := c.clock.Now().UnixNano()
+	c.lease_mu.Lock()
+	items := c.leaseItems(l.typ)
+	if cur, ok := items[l.Key]; !ok || cur != l {
+		c.lease_mu.Unlock()
+		return
+	}
+	expired := l.prune(now)
+	next := l.earliest(now)
+	l.scheduled = next > 0
+	if len(l.holders) == 0 && l.writersWaiting == 0 {
+		delete(items, l.Key)
+	}
+	c.lease_mu.Unlock()
+	c.stats.addExpire(l.typ, expired)
+	if next > 0 {
+		c.timeWheel.AddTimer(leaseTimerDelay(next), timerKey{l.typ, l.Key}, l)
+	}
+}
+
+// Semaphore 计数信号量 每个key最多permits个持有者，租期到期未续期的许可自动释放
+type Semaphore struct {
+	c       *cache
+	permits int
+	ttl     time.Duration
+}
+
+// Permit 已获取的许可
+type Permit struct {
+	c     *cache
+	Key   string
+	Token ID
+}
+
+// NewSemaphore 创建信号量 ttl为许可的租期，0为永不过期 permits不大于0时返回ErrInvalidPermits
+func (c *cache) NewSemaphore(permits int, ttl time.Duration) (*Semaphore, error) {
+	if permits <= 0 {
+		return nil, ErrInvalidPermits
+	}
+	return &Semaphore{c: c, permits: permits, ttl: ttl}, nil
+}
+
+func (s *Semaphore) try(key string) (*Permit, lockWait) {
+	token, w, ok := s.c.leaseAcquire(TypeSemaphore, key, s.ttl, false, func(l *leaseItem) bool {
+		return len(l.holders) < s.permits
+	})
+	if !ok {
+		return nil, w
+	}
+	return &Permit{c: s.c, Key: key, Token: token}, w
+}
+
+// TryAcquire 尝试获取许可 没有可用许可时返回ErrNoPermits
+func (s *Semaphore) TryAcquire(key string) (*Permit, error) {
+	if s.c.isClosed() {
+		return nil, ErrClosed
+	}
+	p, _ := s.try(key)
+	if p == nil {
+		return nil, ErrNoPermits
+	}
+	return p, nil
+}
+
+// Acquire 阻塞获取许可 直到成功或ctx结束
+func (s *Semaphore) Acquire(ctx context.Context, key string) (*Permit, error) {
+	for {
+		if s.c.isClosed() {
+			return nil, ErrClosed
+		}
+		p, w := s.try(key)
+		if p != nil {
+			return p, nil
+		}
+		if err := s.c.waitRelease(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Available 可用的许可数量
+func (s *Semaphore) Available(key string) int {
+	now := s.c.clock.Now().UnixNano()
+	s.c.lease_mu.Lock()
+	defer s.c.lease_mu.Unlock()
+	n := s.permits
+	if l, ok := s.c.semItems[key]; ok {
+		for _, exp := range l.holders {
+			if exp == 0 || exp > now {
+				n--
+			}
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// Release 释放许可 许可已过期时返回ErrPermitNotHeld
+func (p *Permit) Release() error {
+	if !p.c.leaseRelease(TypeSemaphore, p.Key, p.Token) {
+		return ErrPermitNotHeld
+	}
+	return nil
+}
+
+// Extend 续期 租期从现在开始重新计算为ttl
+func (p *Permit) Extend(ttl time.Duration) error {
+	if !p.c.leaseExtend(TypeSemaphore, p.Key, p.Token, ttl) {
+		return ErrPermitNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package speed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if _, err := c.NewSemaphore(0, time.Minute); err != ErrInvalidPermits {
+		t.Fatalf("err = %v, want ErrInvalidPermits", err)
+	}
+	sem, err := c.NewSemaphore(2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, _ := sem.TryAcquire("tenant:1")
+	p2, _ := sem.TryAcquire("tenant:1")
+	if p1 == nil || p2 == nil || p1.Token == p2.Token {
+		t.Fatal("failed to acquire two permits")
+	}
+	if _, err := sem.TryAcquire("tenant:1"); err != ErrNoPermits {
+		t.Fatalf("err = %v, want ErrNoPermits", err)
+	}
+	if sem.Available("tenant:1") != 0 || sem.Available("tenant:2") != 2 {
+		t.Fatal("unexpected available permits")
+	}
+
+	acquired := make(chan *Permit)
+	go func() {
+		p, err := sem.Acquire(context.Background(), "tenant:1")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- p
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more permits than available")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := p1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken by Release")
+	}
+	if err := p1.Release(); err != ErrPermitNotHeld {
+		t.Fatalf("err = %v, want ErrPermitNotHeld", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sem.Acquire(ctx, "tenant:1"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestSemaphoreExpire(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	sem, _ := c.NewSemaphore(1, 30*time.Millisecond)
+	crashed, _ := sem.TryAcquire("jobs")
+	p, err := sem.Acquire(context.Background(), "jobs") //持有者崩溃，租期到期后许可被释放
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crashed.Extend(time.Minute) != ErrPermitNotHeld {
+		t.Fatal("expired permit was extended")
+	}
+	if err := p.Extend(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats()[TypeSemaphore].Expirations < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("permit was not released by the time wheel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.lease_mu.Lock()
+	n := len(c.semItems)
+	c.lease_mu.Unlock()
+	if n != 0 {
+		t.Fatal("expired semaphore was not removed")
+	}
+}
+
+func TestSemaphoreFakeClock(t *testing.T) {
+	c, clock := newFakeCache(t)
+	sem, _ := c.NewSemaphore(1, 10*time.Second)
+	start := clock.Now()
+	sem.TryAcquire("tenant:1")
+	acquired := make(chan *Permit, 1)
+	go func() {
+		p, _ := sem.Acquire(context.Background(), "tenant:1")
+		acquired <- p
+	}()
+	time.Sleep(20 * time.Millisecond)
+	clock.Advance(9900 * time.Millisecond)
+	var p *Permit
+	waitFor(t, func() bool {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case p = <-acquired:
+			return true
+		default:
+			return false
+		}
+	})
+	if d := clock.Now().Sub(start); d > 10500*time.Millisecond || p == nil {
+		t.Fatalf("permit = %+v acquired %v after the first lease", p, d)
+	}
+}
@@ -81,6 +81,8 @@ type Node struct {
 	node  int64
 	step  int64
 
+	generated uint64 // number of IDs generated by this node
+
 	nodeMax   int64
 	nodeMask  int64
 	stepMask  int64
@@ -148,6 +150,7 @@ func (n *Node) Generate() ID {
 	}
 
 	n.time = now
+	n.generated++
 
 	r := ID((now)<<n.timeShift |
 		(n.node << n.nodeShift) |
@@ -158,6 +161,13 @@ func (n *Node) Generate() ID {
 	return r
 }
 
+// Generated returns the number of IDs generated by this node
+func (n *Node) Generated() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.generated
+}
+
 // Int64 returns an int64 of the snowflake ID
 func (f ID) Int64() int64 {
 	return int64(f)
@@ -0,0 +1,142 @@
+package speed
+
+import "sync/atomic"
+
+// typeCounter 单个数据类型的计数器，全部字段通过atomic读写，不加锁
+type typeCounter struct {
+	hits        uint64 //命中
+	misses      uint64 //未命中
+	sets        uint64 //写入
+	deletes     uint64 //主动删除
+	expirations uint64 //过期删除
+	callbacks   uint64 //回调触发次数
+	evictions   uint64 //淘汰次数 预留，目前没有淘汰策略，始终为0
+}
+
+// cacheStats 按数据类型分开统计
+type cacheStats struct {
+	types [dataTypeCount]typeCounter
+}
+
+// TypeStats 单个数据类型的统计快照
+type TypeStats struct {
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64
+	Deletes     uint64
+	Expirations uint64
+	Callbacks   uint64
+	Evictions   uint64
+}
+
+// HitRatio 命中率，没有任何读操作时返回0
+func (s TypeStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Stats 缓存统计快照 key为数据类型
+type Stats map[DataType]TypeStats
+
+// Total 所有数据类型的合计
+func (s Stats) Total() TypeStats {
+	var t TypeStats
+	for _, v := range s {
+		t.Hits += v.Hits
+		t.Misses += v.Misses
+		t.Sets += v.Sets
+		t.Deletes += v.Deletes
+		t.Expirations += v.Expirations
+		t.Callbacks += v.Callbacks
+		t.Evictions += v.Evictions
+	}
+	return t
+}
+
+func (s *cacheStats) hit(t DataType) {
+	atomic.AddUint64(&s.types[t].hits, 1)
+}
+
+func (s *cacheStats) miss(t DataType) {
+	atomic.AddUint64(&s.types[t].misses, 1)
+}
+
+// lookup 根据是否找到记录命中或未命中
+func (s *cacheStats) lookup(t DataType, found bool) {
+	if found {
+		s.hit(t)
+	} else {
+		s.miss(t)
+	}
+}
+
+func (s *cacheStats) set(t DataType) {
+	atomic.AddUint64(&s.types[t].sets, 1)
+}
+
+func (s *cacheStats) delete(t DataType) {
+	atomic.AddUint64(&s.types[t].deletes, 1)
+}
+
+func (s *cacheStats) expire(t DataType) {
+	atomic.AddUint64(&s.types[t].expirations, 1)
+}
+
+// addExpire 一次记录多个过期
+func (s *cacheStats) addExpire(t DataType, n int) {
+	if n > 0 {
+		atomic.AddUint64(&s.types[t].expirations, uint64(n))
+	}
+}
+
+func (s *cacheStats) callback(t DataType) {
+	atomic.AddUint64(&s.types[t].callbacks, 1)
+}
+
+func (s *cacheStats) evict(t DataType) {
+	atomic.AddUint64(&s.types[t].evictions, 1)
+}
+
+func (s *cacheStats) snapshot() Stats {
+	res := make(Stats, dataTypeCount)
+	for i := range s.types {
+		tc := &s.types[i]
+		res[DataType(i)] = TypeStats{
+			Hits:        atomic.LoadUint64(&tc.hits),
+			Misses:      atomic.LoadUint64(&tc.misses),
+			Sets:        atomic.LoadUint64(&tc.sets),
+			Deletes:     atomic.LoadUint64(&tc.deletes),
+			Expirations: atomic.LoadUint64(&tc.expirations),
+			Callbacks:   atomic.LoadUint64(&tc.callbacks),
+			Evictions:   atomic.LoadUint64(&tc.evictions),
+		}
+	}
+	return res
+}
+
+func (s *cacheStats) reset() {
+	for i := range s.types {
+		tc := &s.types[i]
+		atomic.StoreUint64(&tc.hits, 0)
+		atomic.StoreUint64(&tc.misses, 0)
+		atomic.StoreUint64(&tc.sets, 0)
+		atomic.StoreUint64(&tc.deletes, 0)
+		atomic.StoreUint64(&tc.expirations, 0)
+		atomic.StoreUint64(&tc.callbacks, 0)
+		atomic.StoreUint64(&tc.evictions, 0)
+	}
+}
+
+// Stats 获取命中/未命中/写入/删除/过期/回调/淘汰统计快照
+func (c *cache) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// ResetStats 清空统计
+func (c *cache) ResetStats() {
+	c.stats.reset()
+	c.hotKeys.reset()
+}
@@ -0,0 +1,488 @@
+package speed
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	StreamMinID ID = 0             //XRange的最小ID
+	StreamMaxID ID = math.MaxInt64 //XRange的最大ID
+	StreamLast  ID = -1            //XGroupCreate时表示只消费创建之后写入的消息
+)
+
+var (
+	// ErrNoGroup 消费组不存在
+	ErrNoGroup = errors.New("no such consumer group")
+	// ErrGroupExists 消费组已存在
+	ErrGroupExists = errors.New("consumer group already exists")
+)
+
+// StreamEntry 流中的一条消息
+type StreamEntry struct {
+	ID     ID
+	Fields map[string]interface{}
+}
+
+// PendingEntry 已投递给消费者但尚未确认的消息
+type PendingEntry struct {
+	ID            ID
+	Consumer      string
+	DeliveredAt   time.Time //最近一次投递时间
+	DeliveryCount int       //投递次数
+}
+
+// streamItem 流 消息按ID递增保存
+type streamItem struct {
+	entries []StreamEntry
+	lastID  ID
+	groups  map[string]*streamGroup
+	notify  chan struct{} //有新消息时关闭并替换，用于唤醒阻塞读取
+}
+
+// streamWaiter 流不存在时阻塞读取等待的通道 流创建后作为它的notify，写入时关闭
+type streamWaiter struct {
+	notify chan struct{}
+	n      int //等待的读取数 为0时删除
+}
+
+// streamGroup 消费组
+type streamGroup struct {
+	lastDelivered ID
+	pending       map[ID]*PendingEntry //待确认列表
+}
+
+// XAdd 向流追加消息并返回消息ID，maxLen大于0时只保留最新的maxLen条
+func (c *cache) XAdd(key string, maxLen int, fields map[string]interface{}) ID {
+	if c.isClosed() {
+		return 0
+	}
+	entry := StreamEntry{Fields: make(map[string]interface{}, len(fields))}
+	for field, val := range fields {
+		entry.Fields[field] = val
+	}
+	c.stream_mu.Lock()
+	_, ok := c.streamItems[key]
+	s := c.stream(key)
+	entry.ID = c.snowflake.Generate()
+	if entry.ID <= s.lastID { //雪花ID在同一节点内递增，这里防止时钟回拨
+		entry.ID = s.lastID + 1
+	}
+	s.lastID = entry.ID
+	s.entries = append(s.entries, entry)
+	if maxLen > 0 {
+		s.trim(maxLen)
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+	c.stream_mu.Unlock()
+	c.stats.set(TypeStream)
+	c.notify(writeEvent(ok), TypeStream, key)
+	return entry.ID
+}
+
+// XLen 获取流中的消息数量
+func (c *cache) XLen(key string) int {
+	c.stream_mu.RLock()
+	defer c.stream_mu.RUnlock()
+	s, ok := c.streamItems[key]
+	c.stats.lookup(TypeStream, ok)
+	if !ok {
+		return 0
+	}
+	return len(s.entries)
+}
+
+// XRange 按ID从小到大获取[start, end]之间的消息 count大于0时最多返回count条
+func (c *cache) XRange(key string, start, end ID, count int) []StreamEntry {
+	c.stream_mu.RLock()
+	defer c.stream_mu.RUnlock()
+	s, ok := c.streamItems[key]
+	c.stats.lookup(TypeStream, ok)
+	res := make([]StreamEntry, 0)
+	if !ok {
+		return res
+	}
+	for i := s.search(start); i < len(s.entries) && s.entries[i].ID <= end; i++ {
+		if count > 0 && len(res) >= count {
+			break
+		}
+		res = append(res, s.entries[i].clone())
+	}
+	return res
+}
+
+// XRevRange 按ID从大到小获取[start, end]之间的消息 count大于0时最多返回count条
+func (c *cache) XRevRange(key string, end, start ID, count int) []StreamEntry {
+	c.stream_mu.RLock()
+	defer c.stream_mu.RUnlock()
+	s, ok := c.streamItems[key]
+	c.stats.lookup(TypeStream, ok)
+	res := make([]StreamEntry, 0)
+	if !ok {
+		return res
+	}
+	for i := s.upper(end) - 1; i >= 0 && s.entries[i].ID >= start; i-- {
+		if count > 0 && len(res) >= count {
+			break
+		}
+		res = append(res, s.entries[i].clone())
+	}
+	return res
+}
+
+// XRead 获取ID大于after的消息 count大于0时最多返回count条
+func (c *cache) XRead(key string, after ID, count int) []StreamEntry {
+	c.stream_mu.RLock()
+	defer c.stream_mu.RUnlock()
+	s, ok := c.streamItems[key]
+	c.stats.lookup(TypeStream, ok)
+	if !ok {
+		return []StreamEntry{}
+	}
+	return s.after(after, count)
+}
+
+// XReadBlock 同XRead，没有消息时阻塞到有新消息写入或ctx结束 缓存关闭后没有消息时返回ErrClosed，已在等待的读取也会返回
+// 流不存在时不会创建，等待流被写入
+func (c *cache) XReadBlock(ctx context.Context, key string, after ID, count int) ([]StreamEntry, error) {
+	for {
+		c.stream_mu.Lock()
+		var res []StreamEntry
+		var notify chan struct{}
+		if s, ok := c.streamItems[key]; ok {
+			res = s.after(after, count)
+			notify = s.notify
+		}
+		if len(res) > 0 {
+			c.stream_mu.Unlock()
+			c.stats.hit(TypeStream)
+			return res, nil
+		}
+		if c.isClosed() { //关闭后不会再有新消息
+			c.stream_mu.Unlock()
+			c.stats.miss(TypeStream)
+			return nil, ErrClosed
+		}
+		var w *streamWaiter
+		if notify == nil {
+			w = c.streamWaiters[key]
+			if w == nil {
+				w = &streamWaiter{notify: make(chan struct{})}
+				c.streamWaiters[key] = w
+			}
+			w.n++
+			notify = w.notify
+		}
+		c.stream_mu.Unlock()
+		select {
+		case <-notify:
+			c.releaseStreamWaiter(key, w)
+		case <-ctx.Done():
+			c.releaseStreamWaiter(key, w)
+			c.stats.miss(TypeStream)
+			return nil, ctx.Err()
+		case <-c.closing:
+			c.releaseStreamWaiter(key, w)
+			c.stats.miss(TypeStream)
+			return nil, ErrClosed
+		case <-c.ctx.Done():
+			c.releaseStreamWaiter(key, w)
+			c.stats.miss(TypeStream)
+			return nil, ErrClosed
+		}
+	}
+}
+
+// releaseStreamWaiter 阻塞读取结束等待 没有读取等待时删除
+func (c *cache) releaseStreamWaiter(key string, w *streamWaiter) {
+	if w == nil {
+		return
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	w.n--
+	if w.n == 0 && c.streamWaiters[key] == w {
+		delete(c.streamWaiters, key)
+	}
+}
+
+// XDel 删除指定ID的消息 返回删除数量
+func (c *cache) XDel(key string, ids ...ID) int {
+	if c.isClosed() {
+		return 0
+	}
+	c.stream_mu.Lock()
+	s, ok := c.streamItems[key]
+	n := 0
+	if ok {
+		for _, id := range ids {
+			i := s.search(id)
+			if i < len(s.entries) && s.entries[i].ID == id {
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				n++
+			}
+		}
+	}
+	c.stream_mu.Unlock()
+	if n > 0 {
+		c.stats.delete(TypeStream)
+		c.notify(EventDelete, TypeStream, key)
+	}
+	return n
+}
+
+// XTrim 只保留最新的maxLen条消息 返回删除数量
+func (c *cache) XTrim(key string, maxLen int) int {
+	if c.isClosed() {
+		return 0
+	}
+	c.stream_mu.Lock()
+	n := 0
+	if s, ok := c.streamItems[key]; ok {
+		n = s.trim(maxLen)
+	}
+	c.stream_mu.Unlock()
+	if n > 0 {
+		c.stats.delete(TypeStream)
+		c.notify(EventDelete, TypeStream, key)
+	}
+	return n
+}
+
+// XGroupCreate 创建消费组 start为StreamLast时只消费之后写入的消息，否则消费ID大于start的消息
+// 流不存在时自动创建
+func (c *cache) XGroupCreate(key, group string, start ID) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	s := c.stream(key)
+	if _, ok := s.groups[group]; ok {
+		return ErrGroupExists
+	}
+	if start == StreamLast {
+		start = s.lastID
+	}
+	s.groups[group] = &streamGroup{lastDelivered: start, pending: map[ID]*PendingEntry{}}
+	return nil
+}
+
+// XGroupDestroy 删除消费组以及待确认列表
+func (c *cache) XGroupDestroy(key, group string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	s, ok := c.streamItems[key]
+	if !ok {
+		return false
+	}
+	if _, ok := s.groups[group]; !ok {
+		return false
+	}
+	delete(s.groups, group)
+	return true
+}
+
+// XReadGroup 以消费者身份读取消费组中尚未投递的消息，读取的消息进入待确认列表直到XAck
+func (c *cache) XReadGroup(key, group, consumer string, count int) ([]StreamEntry, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	res, _, err := c.readGroup(key, group, consumer, count)
+	return res, err
+}
+
+// XReadGroupBlock 同XReadGroup，没有消息时阻塞到有新消息写入或ctx结束 缓存关闭时返回ErrClosed
+func (c *cache) XReadGroupBlock(ctx context.Context, key, group, consumer string, count int) ([]StreamEntry, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	for {
+		c.stream_mu.Lock()
+		res, notify, err := c.readGroup(key, group, consumer, count)
+		c.stream_mu.Unlock()
+		if err != nil || len(res) > 0 {
+			return res, err
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.closing:
+			return nil, ErrClosed
+		case <-c.ctx.Done():
+			return nil, ErrClosed
+		}
+	}
+}
+
+// readGroup 调用方需持有stream_mu写锁
+func (c *cache) readGroup(key, group, consumer string, count int) ([]StreamEntry, chan struct{}, error) {
+	s, ok := c.streamItems[key]
+	if !ok {
+		return nil, nil, ErrNoGroup
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, nil, ErrNoGroup
+	}
+	res := s.after(g.lastDelivered, count)
+	c.stats.lookup(TypeStream, len(res) > 0)
+	now := c.clock.Now()
+	for _, entry := range res {
+		g.pending[entry.ID] = &PendingEntry{ID: entry.ID, Consumer: consumer, DeliveredAt: now, DeliveryCount: 1}
+		g.lastDelivered = entry.ID
+	}
+	return res, s.notify, nil
+}
+
+// XPending 获取消费组的待确认列表 按ID排序
+func (c *cache) XPending(key, group string) ([]PendingEntry, error) {
+	c.stream_mu.RLock()
+	defer c.stream_mu.RUnlock()
+	s, ok := c.streamItems[key]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	res := make([]PendingEntry, 0, len(g.pending))
+	for _, p := range g.pending {
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// XClaim 将空闲时间超过minIdle的待确认消息转给consumer并重新返回 用于消费者崩溃后接管消息
+func (c *cache) XClaim(key, group, consumer string, minIdle time.Duration, ids ...ID) ([]StreamEntry, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	s, ok := c.streamItems[key]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	now := c.clock.Now()
+	res := make([]StreamEntry, 0, len(ids))
+	for _, id := range ids {
+		p, ok := g.pending[id]
+		if !ok || now.Sub(p.DeliveredAt) < minIdle {
+			continue
+		}
+		i := s.search(id)
+		if i >= len(s.entries) || s.entries[i].ID != id { //消息已被删除
+			delete(g.pending, id)
+			continue
+		}
+		p.Consumer = consumer
+		p.DeliveredAt = now
+		p.DeliveryCount++
+		res = append(res, s.entries[i].clone())
+	}
+	return res, nil
+}
+
+// XAck 确认消息，从消费组的待确认列表中移除 返回确认数量
+func (c *cache) XAck(key, group string, ids ...ID) (int, error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	c.stream_mu.Lock()
+	defer c.stream_mu.Unlock()
+	s, ok := c.streamItems[key]
+	if !ok {
+		return 0, ErrNoGroup
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		return 0, ErrNoGroup
+	}
+	n := 0
+	for _, id := range ids {
+		if _, ok := g.pending[id]; ok {
+			delete(g.pending, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// stream 获取流，不存在时创建 创建时接管等待该key的阻塞读取 调用方需持有stream_mu写锁
+func (c *cache) stream(key string) *streamItem {
+	s, ok := c.streamItems[key]
+	if !ok {
+		notify := make(chan struct{})
+		if w, ok := c.streamWaiters[key]; ok {
+			notify = w.notify
+			delete(c.streamWaiters, key)
+		}
+		s = &streamItem{groups: map[string]*streamGroup{}, notify: notify}
+		c.streamItems[key] = s
+	}
+	return s
+}
+
+// search 第一个ID大于等于id的位置
+func (s *streamItem) search(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].ID >= id
+	})
+}
+
+// upper 第一个ID大于id的位置
+func (s *streamItem) upper(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].ID > id
+	})
+}
+
+// after ID大于id的消息
+func (s *streamItem) after(id ID, count int) []StreamEntry {
+	i := s.upper(id)
+	n := len(s.entries) - i
+	if count > 0 && n > count {
+		n = count
+	}
+	res := make([]StreamEntry, n)
+	for j := range res {
+		res[j] = s.entries[i+j].clone()
+	}
+	return res
+}
+
+// clone 复制Fields，调用方修改返回的消息不影响流中保存的消息
+func (e StreamEntry) clone() StreamEntry {
+	fields := make(map[string]interface{}, len(e.Fields))
+	for field, val := range e.Fields {
+		fields[field] = val
+	}
+	return StreamEntry{ID: e.ID, Fields: fields}
+}
+
+// trim 只保留最新的maxLen条消息 返回删除数量
+func (s *streamItem) trim(maxLen int) int {
+	if maxLen < 0 || len(s.entries) <= maxLen {
+		return 0
+	}
+	n := len(s.entries) - maxLen
+	s.entries = append(s.entries[:0:0], s.entries[n:]...)
+	return n
+}
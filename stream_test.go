@@ -0,0 +1,163 @@
+package speed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	var ids []ID
+	for i := 0; i < 5; i++ {
+		ids = append(ids, c.XAdd("events", 3, map[string]interface{}{"n": i}))
+	}
+	if c.XLen("events") != 3 {
+		t.Fatalf("XLen = %d, want 3 after MAXLEN trimming", c.XLen("events"))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids not increasing: %v", ids)
+		}
+	}
+	entries := c.XRange("events", StreamMinID, StreamMaxID, 0)
+	if len(entries) != 3 || entries[0].ID != ids[2] || entries[0].Fields["n"] != 2 {
+		t.Fatalf("unexpected XRange %v", entries)
+	}
+	if rev := c.XRevRange("events", StreamMaxID, StreamMinID, 1); len(rev) != 1 || rev[0].ID != ids[4] {
+		t.Fatalf("unexpected XRevRange %v", rev)
+	}
+	if got := c.XRead("events", ids[3], 0); len(got) != 1 || got[0].ID != ids[4] {
+		t.Fatalf("unexpected XRead %v", got)
+	}
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.XAdd("events", 0, map[string]interface{}{"n": 1})
+	}()
+	got, err := c.XReadBlock(ctx, "events", StreamMinID, 0)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("XReadBlock = %v, %v", got, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.XReadBlock(ctx, "events", got[0].ID, 0); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestStreamConsumerGroup(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.XAdd("jobs", 0, map[string]interface{}{"n": 0})
+	if err := c.XGroupCreate("jobs", "workers", StreamLast); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.XGroupCreate("jobs", "workers", StreamLast); err != ErrGroupExists {
+		t.Fatalf("err = %v, want ErrGroupExists", err)
+	}
+	id1 := c.XAdd("jobs", 0, map[string]interface{}{"n": 1})
+	id2 := c.XAdd("jobs", 0, map[string]interface{}{"n": 2})
+
+	got, err := c.XReadGroup("jobs", "workers", "alice", 1)
+	if err != nil || len(got) != 1 || got[0].ID != id1 {
+		t.Fatalf("XReadGroup = %v, %v", got, err)
+	}
+	got, _ = c.XReadGroup("jobs", "workers", "bob", 0)
+	if len(got) != 1 || got[0].ID != id2 {
+		t.Fatalf("XReadGroup = %v", got)
+	}
+	pending, _ := c.XPending("jobs", "workers")
+	if len(pending) != 2 || pending[0].Consumer != "alice" || pending[1].Consumer != "bob" {
+		t.Fatalf("unexpected pending list %+v", pending)
+	}
+	if claimed, _ := c.XClaim("jobs", "workers", "bob", 0, id1); len(claimed) != 1 {
+		t.Fatalf("XClaim = %v", claimed)
+	}
+	if n, _ := c.XAck("jobs", "workers", id1, id2); n != 2 {
+		t.Fatalf("XAck = %d, want 2", n)
+	}
+	if pending, _ = c.XPending("jobs", "workers"); len(pending) != 0 {
+		t.Fatalf("pending not empty after XAck: %+v", pending)
+	}
+	if _, err := c.XReadGroup("jobs", "missing", "alice", 0); err != ErrNoGroup {
+		t.Fatalf("err = %v, want ErrNoGroup", err)
+	}
+}
+
+func TestStreamFlushWakesReaders(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.XAdd("jobs", 0, map[string]interface{}{"n": 0})
+	c.XGroupCreate("jobs", "workers", StreamLast)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.XReadGroupBlock(ctx, "jobs", "workers", "alice", 0)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Flush()
+	select {
+	case err := <-done:
+		if err != ErrNoGroup {
+			t.Fatalf("err = %v, want ErrNoGroup", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush did not wake the blocked reader")
+	}
+}
+
+func TestStreamBlockingReadMissingKey(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.XReadBlock(ctx, "events", StreamMinID, 0); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	c.stream_mu.RLock()
+	streams, waiters := len(c.streamItems), len(c.streamWaiters)
+	c.stream_mu.RUnlock()
+	if streams != 0 || waiters != 0 {
+		t.Fatalf("blocking read left %d streams and %d waiters", streams, waiters)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.XAdd("events", 0, map[string]interface{}{"n": 1})
+	}()
+	got, err := c.XReadBlock(ctx, "events", StreamMinID, 0)
+	if err != nil || len(got) != 1 || got[0].Fields["n"] != 1 {
+		t.Fatalf("XReadBlock = %v, %v", got, err)
+	}
+	got[0].Fields["n"] = 2
+	if entries := c.XRange("events", StreamMinID, StreamMaxID, 0); entries[0].Fields["n"] != 1 {
+		t.Fatal("modifying a read entry changed the stream")
+	}
+}
@@ -2,6 +2,9 @@ package speed
 
 import (
 	"container/list"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,46 +13,157 @@ type Job func(interface{})
 
 // TaskData 回调函数参数类型
 
-// TimeWheel 时间轮
+// upperLevelSlots 上层时间轮的槽数量 第一层为NewTw传入的slotNum
+// interval为1秒、slotNum为60时四层分别为秒、分、时、天
+var upperLevelSlots = []int{60, 24, 365}
+
+// TimeWheel 分层时间轮
+// 第0层每个槽为一个interval，第i层每个槽的跨度为第i-1层整圈的跨度
+// 定时器放在能容纳其到期时间的最低层，上层的槽转到时把其中的定时器重新放入下层(降级)
+// 每次tick只处理第0层当前槽中确实到期的定时器，以及偶尔需要降级的上层槽
 type TimeWheel struct {
 	interval time.Duration // 指针每隔多久往前移动一格
-	ticker   *time.Ticker
-	slots    []*list.List // 时间轮槽
-	// key: 定时器唯一标识 value: 定时器所在的槽, 主要用于删除定时器, 不会出现并发读写，不加锁直接访问
-	timer             map[interface{}]int
-	currentPos        int              // 当前指针指向哪一个槽
-	slotNum           int              // 槽数量
-	job               Job              // 定时器回调函数
-	addTaskChannel    chan Task        // 新增任务channel
-	removeTaskChannel chan interface{} // 删除任务channel
-	stopChannel       chan bool        // 停止定时器channel
-	C                 chan interface{} //时间轮通知通道
+	clock    Clock
+	ticker   Ticker
+	levels   []*wheelLevel // 各层时间轮
+	// key: 定时器唯一标识 value: 定时器所在的链表元素, 用于O(1)删除和重置定时器, 不会出现并发读写，不加锁直接访问
+	timer        map[interface{}]*list.Element
+	ticks        int64            // 已经走过的tick数
+	lastTick     time.Time        // 最近一次前进的时间 用于估算定时器的触发时间
+	slotNum      int              // 第0层槽数量
+	job          Job              // 定时器回调函数
+	opChannel    chan timerOp     // 新增、删除、重置定时器共用一个channel，保证同一调用方的操作按顺序执行
+	stopChannel  chan bool        // 停止定时器channel
+	queryChannel chan func()      // 查询channel 查询函数在时间轮协程内执行
+	doneChannel  chan struct{}    // 时间轮协程退出后关闭
+	C            chan interface{} //时间轮通知通道
+	overflow     OverflowPolicy   // channel满时的处理方式
+	// opChannel满时溢出的操作 不为空时后续操作也进入溢出队列，保证顺序
+	spillMu      sync.Mutex
+	spill        []timerOp
+	spillSignal  chan struct{}
+	backlog      []interface{} // 等待投递到C的到期数据 只在时间轮协程内访问
+	lag          int64         // BlockOnOverflow时因C已满而推迟的tick数
+	droppedOps   uint64        // DropOnOverflow时丢弃的操作数
+	droppedFired uint64        // DropOnOverflow时丢弃的到期数据数
+}
+
+// OverflowPolicy 时间轮channel满时的处理方式
+type OverflowPolicy uint8
+
+const (
+	BlockOnOverflow OverflowPolicy = iota //添加、删除定时器时等待；C满时暂停前进，之后补上推迟的tick
+	DropOnOverflow                        //丢弃并计数
+	SpillOnOverflow                       //放入无界的内部队列
+)
+
+// TwOption 时间轮配置项
+type TwOption func(*TimeWheel)
+
+// WithTwBuffer 设置操作channel和通知通道C的缓冲大小 默认都为10000
+func WithTwBuffer(ops, fired int) TwOption {
+	return func(tw *TimeWheel) {
+		if ops >= 0 {
+			tw.opChannel = make(chan timerOp, ops)
+		}
+		if fired >= 0 {
+			tw.C = make(chan interface{}, fired)
+		}
+	}
+}
+
+// WithTwOverflow 设置channel满时的处理方式 默认BlockOnOverflow
+// 无论哪种方式，时间轮协程都不会阻塞在C上，回调里再调用AddTimer不会死锁
+func WithTwOverflow(policy OverflowPolicy) TwOption {
+	return func(tw *TimeWheel) {
+		tw.overflow = policy
+	}
+}
+
+// WithTwClock 设置时间来源 默认为系统时间
+func WithTwClock(clock Clock) TwOption {
+	return func(tw *TimeWheel) {
+		if clock != nil {
+			tw.clock = clock
+		}
+	}
+}
+
+// TimerInfo 定时器快照
+type TimerInfo struct {
+	Key   interface{} //定时器唯一标识
+	Data  interface{} //回调参数
+	Level int         //所在的层
+	Slot  int         //所在的槽
+	At    time.Time   //预计触发时间 精度为一个interval
+}
+
+// TimeWheelStats 时间轮背压统计
+type TimeWheelStats struct {
+	DroppedOps   uint64 //丢弃的添加、删除、重置操作数
+	DroppedFired uint64 //丢弃的到期通知数
+	Spilled      int    //溢出队列中等待处理的操作数
+	Backlog      int    //等待投递到C的到期通知数
+	Lag          int64  //因C已满而推迟的tick数
+}
+
+// timerOpKind 定时器操作类型
+type timerOpKind int
+
+const (
+	opAdd      timerOpKind = iota // 新增 同key的定时器会被替换
+	opRemove                      // 删除
+	opReset                       // 从当前时间起重新计时
+	opPostpone                    // 在原到期时间上推迟
+)
+
+// timerOp 发给时间轮协程的定时器操作
+type timerOp struct {
+	kind timerOpKind
+	task Task
+}
+
+// wheelLevel 单层时间轮
+type wheelLevel struct {
+	span  int64        // 每个槽跨越的tick数
+	slots []*list.List // 时间轮槽
+}
+
+// taskPos 定时器所在的层和槽
+type taskPos struct {
+	level int
+	slot  int
 }
 
 // Task 延时任务
 type Task struct {
 	delay  time.Duration // 延迟时间
-	circle int           // 时间轮需要转动几圈
+	expire int64         // 到期的tick
 	key    interface{}   // 定时器唯一标识, 用于删除定时器
 	data   interface{}   // 回调函数参数
+	pos    taskPos       // 定时器所在的层和槽
 }
 
 // New 创建时间轮
-func NewTw(interval time.Duration, slotNum int, job Job) *TimeWheel {
+func NewTw(interval time.Duration, slotNum int, job Job, opts ...TwOption) *TimeWheel {
 	if interval <= 0 || slotNum <= 0 {
 		return nil
 	}
 	tw := &TimeWheel{
-		interval:          interval,
-		slots:             make([]*list.List, slotNum),
-		timer:             make(map[interface{}]int),
-		currentPos:        0,
-		job:               job,
-		slotNum:           slotNum,
-		addTaskChannel:    make(chan Task, 10000),
-		removeTaskChannel: make(chan interface{}, 10000),
-		stopChannel:       make(chan bool),
-		C:                 make(chan interface{}, 10000),
+		interval:     interval,
+		timer:        make(map[interface{}]*list.Element),
+		job:          job,
+		slotNum:      slotNum,
+		opChannel:    make(chan timerOp, 10000),
+		stopChannel:  make(chan bool),
+		queryChannel: make(chan func()),
+		doneChannel:  make(chan struct{}),
+		C:            make(chan interface{}, 10000),
+		spillSignal:  make(chan struct{}, 1),
+		clock:        realClock{},
+	}
+	for _, opt := range opts {
+		opt(tw)
 	}
 
 	tw.initSlots()
@@ -57,30 +171,51 @@ func NewTw(interval time.Duration, slotNum int, job Job) *TimeWheel {
 	return tw
 }
 
-// 初始化槽，每个槽指向一个双向链表
+// 初始化各层的槽，每个槽指向一个双向链表
 func (tw *TimeWheel) initSlots() {
-	for i := 0; i < tw.slotNum; i++ {
-		tw.slots[i] = list.New()
+	span := int64(1)
+	for _, n := range append([]int{tw.slotNum}, upperLevelSlots...) {
+		level := &wheelLevel{span: span, slots: make([]*list.List, n)}
+		for i := range level.slots {
+			level.slots[i] = list.New()
+		}
+		tw.levels = append(tw.levels, level)
+		span *= int64(n)
 	}
 }
 
 // Start 启动时间轮
 func (tw *TimeWheel) Start() {
-	tw.ticker = time.NewTicker(tw.interval)
+	tw.ticker = tw.clock.NewTicker(tw.interval)
 	go tw.start()
 }
 
-// Stop 停止时间轮
+// Stop 停止时间轮 可以重复调用
 func (tw *TimeWheel) Stop() {
-	tw.stopChannel <- true
+	select {
+	case tw.stopChannel <- true:
+	case <-tw.doneChannel:
+	}
+}
+
+// Shutdown 停止时间轮并等待协程退出
+// 返回已到期但还没投递到C的数据，以及还未到期的定时器的数据，C中已有的数据由调用方继续读取
+func (tw *TimeWheel) Shutdown() (fired, pending []interface{}) {
+	tw.Stop()
+	<-tw.doneChannel
+	fired, tw.backlog = tw.backlog, nil
+	tw.each(func(task *Task) {
+		pending = append(pending, task.data)
+	})
+	return fired, pending
 }
 
-// AddTimer 添加定时器 key为定时器唯一标识
+// AddTimer 添加定时器 key为定时器唯一标识，已存在同key的定时器时替换原定时器
 func (tw *TimeWheel) AddTimer(delay time.Duration, key interface{}, data interface{}) {
 	if delay <= 0 {
 		return
 	}
-	tw.addTaskChannel <- Task{delay: delay, key: key, data: data}
+	tw.send(timerOp{kind: opAdd, task: Task{delay: delay, key: key, data: data}})
 }
 
 // RemoveTimer 删除定时器 key为添加定时器时传递的定时器唯一标识
@@ -88,18 +223,227 @@ func (tw *TimeWheel) RemoveTimer(key interface{}) {
 	if key == nil {
 		return
 	}
-	tw.removeTaskChannel <- key
+	tw.send(timerOp{kind: opRemove, task: Task{key: key}})
+}
+
+// ResetTimer 把定时器的到期时间重置为从现在起delay之后 回调参数不变，定时器不存在时忽略
+func (tw *TimeWheel) ResetTimer(key interface{}, delay time.Duration) {
+	if key == nil || delay <= 0 {
+		return
+	}
+	tw.send(timerOp{kind: opReset, task: Task{delay: delay, key: key}})
+}
+
+// Postpone 把定时器的到期时间在原来的基础上推迟delay 回调参数不变，定时器不存在时忽略
+func (tw *TimeWheel) Postpone(key interface{}, delay time.Duration) {
+	if key == nil || delay <= 0 {
+		return
+	}
+	tw.send(timerOp{kind: opPostpone, task: Task{delay: delay, key: key}})
+}
+
+// send 按溢出策略把操作发给时间轮协程
+func (tw *TimeWheel) send(op timerOp) {
+	switch tw.overflow {
+	case DropOnOverflow:
+		select {
+		case tw.opChannel <- op:
+		default:
+			atomic.AddUint64(&tw.droppedOps, 1)
+		}
+	case SpillOnOverflow:
+		tw.spillMu.Lock()
+		if len(tw.spill) == 0 {
+			select {
+			case tw.opChannel <- op:
+				tw.spillMu.Unlock()
+				return
+			default:
+			}
+		}
+		tw.spill = append(tw.spill, op)
+		tw.spillMu.Unlock()
+		select {
+		case tw.spillSignal <- struct{}{}:
+		default:
+		}
+	default:
+		select {
+		case tw.opChannel <- op:
+		case <-tw.doneChannel: //已停止 不再等待
+		}
+	}
+}
+
+// drainOps 处理已经发出的操作 先处理channel中的，再处理溢出队列中更晚的
+func (tw *TimeWheel) drainOps() {
+	tw.spillMu.Lock()
+	n := len(tw.opChannel)
+	ops := tw.spill
+	tw.spill = nil
+	tw.spillMu.Unlock()
+	for i := 0; i < n; i++ {
+		tw.handleOp(<-tw.opChannel)
+	}
+	for _, op := range ops {
+		tw.handleOp(op)
+	}
+}
+
+// Stats 获取背压统计
+func (tw *TimeWheel) Stats() TimeWheelStats {
+	st := TimeWheelStats{
+		DroppedOps:   atomic.LoadUint64(&tw.droppedOps),
+		DroppedFired: atomic.LoadUint64(&tw.droppedFired),
+	}
+	tw.spillMu.Lock()
+	st.Spilled = len(tw.spill)
+	tw.spillMu.Unlock()
+	tw.query(func() {
+		st.Backlog = len(tw.backlog)
+		st.Lag = tw.lag
+	})
+	return st
+}
+
+// Pending 获取等待触发的定时器总数
+func (tw *TimeWheel) Pending() int {
+	var n int
+	tw.query(func() {
+		for _, level := range tw.levels {
+			for _, l := range level.slots {
+				n += l.Len()
+			}
+		}
+	})
+	return n
+}
+
+// Lookup 查询定时器 返回预计触发时间，不存在时ok为false
+func (tw *TimeWheel) Lookup(key interface{}) (info TimerInfo, ok bool) {
+	tw.query(func() {
+		var e *list.Element
+		if e, ok = tw.timer[key]; ok {
+			info = tw.info(e.Value.(*Task))
+		}
+	})
+	return
+}
+
+// NextExpiry 获取最早的预计触发时间 没有定时器时ok为false
+func (tw *TimeWheel) NextExpiry() (at time.Time, ok bool) {
+	tw.query(func() {
+		var next *Task
+		tw.each(func(task *Task) {
+			if next == nil || task.expire < next.expire {
+				next = task
+			}
+		})
+		if next != nil {
+			at, ok = tw.expireTime(next.expire), true
+		}
+	})
+	return
+}
+
+// Timers 获取全部定时器的快照 按预计触发时间排序，用于排查没有随数据删除的定时器
+func (tw *TimeWheel) Timers() []TimerInfo {
+	var timers []TimerInfo
+	tw.query(func() {
+		tw.each(func(task *Task) {
+			timers = append(timers, tw.info(task))
+		})
+	})
+	sort.SliceStable(timers, func(i, j int) bool {
+		return timers[i].At.Before(timers[j].At)
+	})
+	return timers
+}
+
+// each 遍历全部定时器 只能在时间轮协程内调用
+func (tw *TimeWheel) each(f func(task *Task)) {
+	for _, level := range tw.levels {
+		for _, l := range level.slots {
+			for e := l.Front(); e != nil; e = e.Next() {
+				f(e.Value.(*Task))
+			}
+		}
+	}
+}
+
+func (tw *TimeWheel) info(task *Task) TimerInfo {
+	return TimerInfo{
+		Key:   task.key,
+		Data:  task.data,
+		Level: task.pos.level,
+		Slot:  task.pos.slot,
+		At:    tw.expireTime(task.expire),
+	}
+}
+
+// expireTime 估算到期tick对应的时间 C满时推迟的tick也计算在内
+func (tw *TimeWheel) expireTime(expire int64) time.Time {
+	return tw.lastTick.Add(time.Duration(expire-tw.ticks+tw.lag) * tw.interval)
+}
+
+// SlotCounts 获取每层每个槽中的定时器数量 第一维为层
+func (tw *TimeWheel) SlotCounts() [][]int {
+	var counts [][]int
+	tw.query(func() {
+		counts = make([][]int, len(tw.levels))
+		for i, level := range tw.levels {
+			counts[i] = make([]int, len(level.slots))
+			for j, l := range level.slots {
+				counts[i][j] = l.Len()
+			}
+		}
+	})
+	return counts
+}
+
+// query 在时间轮协程内执行f并等待完成，时间轮已停止时直接返回
+func (tw *TimeWheel) query(f func()) {
+	done := make(chan struct{})
+	select {
+	case tw.queryChannel <- func() {
+		f()
+		close(done)
+	}:
+		<-done
+	case <-tw.doneChannel:
+	}
 }
 
 func (tw *TimeWheel) start() {
+	defer close(tw.doneChannel)
+	tw.lastTick = tw.clock.Now()
 	for {
+		for tw.lag > 0 && len(tw.backlog) == 0 { //补上C满时推迟的tick
+			tw.lag--
+			tw.tickHandler()
+		}
+		var out chan interface{}
+		var next interface{}
+		if len(tw.backlog) > 0 {
+			out, next = tw.C, tw.backlog[0]
+		}
 		select {
-		case <-tw.ticker.C:
+		case <-tw.ticker.Chan():
+			tw.drainOps() //tick之前已经发出的操作先生效
+			if tw.overflow == BlockOnOverflow && len(tw.backlog) > 0 {
+				tw.lag++
+				continue
+			}
 			tw.tickHandler()
-		case task := <-tw.addTaskChannel:
-			tw.addTask(&task)
-		case key := <-tw.removeTaskChannel:
-			tw.removeTask(key)
+		case out <- next:
+			tw.backlog[0] = nil
+			tw.backlog = tw.backlog[1:]
+		case op := <-tw.opChannel:
+			tw.handleOp(op)
+		case <-tw.spillSignal:
+			tw.drainOps()
+		case f := <-tw.queryChannel:
+			f()
 		case <-tw.stopChannel:
 			tw.ticker.Stop()
 			return
@@ -107,78 +451,145 @@ func (tw *TimeWheel) start() {
 	}
 }
 
+// handleOp 执行定时器操作
+func (tw *TimeWheel) handleOp(op timerOp) {
+	switch op.kind {
+	case opAdd:
+		task := op.task
+		tw.removeTask(task.key)
+		tw.addTask(&task)
+	case opRemove:
+		tw.removeTask(op.task.key)
+	case opReset, opPostpone:
+		e, ok := tw.timer[op.task.key]
+		if !ok {
+			return
+		}
+		task := e.Value.(*Task)
+		tw.levels[task.pos.level].slots[task.pos.slot].Remove(e)
+		if op.kind == opReset {
+			task.delay = op.task.delay
+			tw.addTask(task)
+			return
+		}
+		task.delay += op.task.delay
+		task.expire += int64(op.task.delay / tw.interval)
+		tw.place(task)
+	}
+}
+
+// tickHandler 前进一格 先从高到低把转到的上层槽降级，再执行第0层当前槽中的定时器
 func (tw *TimeWheel) tickHandler() {
-	l := tw.slots[tw.currentPos]       //当前时间槽位
-	tw.scanAndRunTask(l)               //执行槽位内双向链表的任务
-	if tw.currentPos == tw.slotNum-1 { //如果再最后一个槽位
-		tw.currentPos = 0
-	} else {
-		tw.currentPos++
+	tw.ticks++
+	tw.lastTick = tw.clock.Now()
+	for i := len(tw.levels) - 1; i > 0; i-- {
+		level := tw.levels[i]
+		if tw.ticks%level.span == 0 {
+			tw.cascade(level.slots[int(tw.ticks/level.span)%len(level.slots)])
+		}
 	}
+	l := tw.levels[0].slots[int(tw.ticks%int64(tw.slotNum))] //当前时间槽位
+	tw.scanAndRunTask(l)                                     //执行槽位内双向链表的任务
 }
 
-// 扫描链表中过期定时器, 并执行回调函数
+// cascade 把上层槽中的定时器重新放入时间轮 会落到更低的层或者立即到期
+func (tw *TimeWheel) cascade(l *list.List) {
+	var tasks, due []*Task
+	for e := l.Front(); e != nil; e = e.Next() {
+		tasks = append(tasks, e.Value.(*Task))
+	}
+	l.Init() //先清空 超出最高层范围的定时器会放回同一个槽
+	for _, task := range tasks {
+		if task.expire <= tw.ticks {
+			due = append(due, task)
+			continue
+		}
+		tw.place(task)
+	}
+	for _, task := range due {
+		tw.forget(task)
+		tw.run(task)
+	}
+}
+
+// 扫描链表中到期的定时器, 并执行回调函数
 func (tw *TimeWheel) scanAndRunTask(l *list.List) {
 	for e := l.Front(); e != nil; {
 		task := e.Value.(*Task)
-		if task.circle > 0 {
-			task.circle--
-			e = e.Next()
-			continue
-		}
-		if tw.job != nil {
-			go tw.job(task.data)
-		}
-		if tw.C != nil {
-			tw.C <- task.data
-		}
 		next := e.Next()
 		l.Remove(e)
-		if task.key != nil {
-			delete(tw.timer, task.key)
-		}
+		tw.forget(task)
+		tw.run(task)
 		e = next
 	}
 }
 
+func (tw *TimeWheel) run(task *Task) {
+	if tw.job != nil {
+		go tw.job(task.data)
+	}
+	if tw.C == nil {
+		return
+	}
+	if len(tw.backlog) == 0 {
+		select {
+		case tw.C <- task.data:
+			return
+		default:
+		}
+	}
+	if tw.overflow == DropOnOverflow {
+		atomic.AddUint64(&tw.droppedFired, 1)
+		return
+	}
+	tw.backlog = append(tw.backlog, task.data) //不阻塞在C上，由时间轮协程在select中投递
+}
+
 // 新增任务到链表中
 func (tw *TimeWheel) addTask(task *Task) {
-	pos, circle := tw.getPositionAndCircle(task.delay)
-	task.circle = circle
+	task.expire = tw.ticks + int64(task.delay/tw.interval) + 1
+	tw.place(task)
+}
 
-	tw.slots[pos].PushBack(task)
+// place 把定时器放到能容纳其到期时间的最低层 超出最高层范围时放在最高层，转到时再重新放置
+func (tw *TimeWheel) place(task *Task) {
+	level, slot := tw.getPosition(task.expire)
+	task.pos = taskPos{level, slot}
+	e := tw.levels[level].slots[slot].PushBack(task)
 
 	if task.key != nil {
-		tw.timer[task.key] = pos
+		tw.timer[task.key] = e
 	}
 }
 
-// 获取定时器在槽中的位置, 时间轮需要转动的圈数
-func (tw *TimeWheel) getPositionAndCircle(d time.Duration) (pos int, circle int) {
-	delaySeconds := int(d.Seconds())
-	intervalSeconds := int(tw.interval.Seconds())
-	circle = int(delaySeconds / intervalSeconds / tw.slotNum)
-	pos = int(tw.currentPos+delaySeconds/intervalSeconds) % tw.slotNum
+// forget 定时器离开时间轮后删除其索引
+func (tw *TimeWheel) forget(task *Task) {
+	if task.key != nil {
+		delete(tw.timer, task.key)
+	}
+}
 
-	return
+// 获取定时器所在的层和槽
+func (tw *TimeWheel) getPosition(expire int64) (level int, slot int) {
+	for i, l := range tw.levels {
+		if expire/l.span-tw.ticks/l.span < int64(len(l.slots)) {
+			return i, int(expire/l.span) % len(l.slots)
+		}
+	}
+	top := tw.levels[len(tw.levels)-1]
+	return len(tw.levels) - 1, int(expire/top.span) % len(top.slots)
 }
 
-// 从链表中删除任务
+// 从链表中删除任务 通过索引直接定位链表元素
 func (tw *TimeWheel) removeTask(key interface{}) {
-	// 获取定时器所在的槽
-	position, ok := tw.timer[key]
-	if !ok {
+	if key == nil {
 		return
 	}
-	// 获取槽指向的链表
-	l := tw.slots[position]
-	for e := l.Front(); e != nil; {
-		task := e.Value.(*Task)
-		if task.key == key {
-			delete(tw.timer, task.key)
-			l.Remove(e)
-		}
-
-		e = e.Next()
+	e, ok := tw.timer[key]
+	if !ok {
+		return
 	}
+	task := e.Value.(*Task)
+	tw.levels[task.pos.level].slots[task.pos.slot].Remove(e)
+	delete(tw.timer, key)
 }
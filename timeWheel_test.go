@@ -0,0 +1,306 @@
+package speed
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// advance 在时间轮协程中手动前进n格
+func (tw *TimeWheel) advance(n int) {
+	tw.query(func() {
+		for i := 0; i < n; i++ {
+			tw.tickHandler()
+		}
+	})
+}
+
+// waitPending 等待时间轮协程处理完定时器操作 操作和查询走不同的channel，需要轮询
+func waitPending(t *testing.T, tw *TimeWheel, n int) {
+	t.Helper()
+	waitFor(t, func() bool {
+		total := 0
+		for _, slots := range tw.SlotCounts() {
+			for _, c := range slots {
+				total += c
+			}
+		}
+		return total == n
+	})
+}
+
+// newTestWheel 创建不自动前进的时间轮
+func newTestWheel(t *testing.T, opts ...TwOption) *TimeWheel {
+	tw := NewTw(time.Second, 60, nil, append(opts, WithTwClock(NewFakeClock(time.Now())))...)
+	tw.Start()
+	t.Cleanup(tw.Stop)
+	return tw
+}
+
+func TestTimeWheelLevels(t *testing.T) {
+	tw := newTestWheel(t)
+	delays := []time.Duration{
+		time.Second,
+		59 * time.Second,
+		90 * time.Second,
+		2 * time.Hour,
+		36 * time.Hour,
+		30 * 24 * time.Hour,
+	}
+	for i, d := range delays {
+		tw.AddTimer(d, i, d)
+	}
+	waitPending(t, tw, len(delays))
+	counts := tw.SlotCounts()
+	perLevel := make([]int, len(counts))
+	for level, slots := range counts {
+		for _, n := range slots {
+			perLevel[level] += n
+		}
+	}
+	if perLevel[0] != 1 || perLevel[1] != 2 || perLevel[2] != 1 || perLevel[3] != 2 {
+		t.Fatalf("timers per level = %v", perLevel)
+	}
+
+	var elapsed int
+	for _, d := range delays {
+		ticks := int(d/time.Second) + 1
+		tw.advance(ticks - 1 - elapsed)
+		if len(tw.C) != 0 {
+			t.Fatalf("timer %v fired early", d)
+		}
+		tw.advance(1)
+		elapsed = ticks
+		select {
+		case v := <-tw.C:
+			if v != d {
+				t.Fatalf("fired %v, want %v", v, d)
+			}
+		default:
+			t.Fatalf("timer %v did not fire on time", d)
+		}
+	}
+}
+
+func TestTimeWheelBeyondTopLevel(t *testing.T) {
+	defer func(slots []int) { upperLevelSlots = slots }(upperLevelSlots)
+	upperLevelSlots = []int{60, 24, 2} //最高层一圈只有两天
+	tw := newTestWheel(t)
+	d := 5 * 24 * time.Hour
+	tw.AddTimer(d, "far", "far")
+	waitPending(t, tw, 1)
+	tw.advance(int(d/time.Second) + 1 - 3601)
+	if len(tw.C) != 0 {
+		t.Fatal("timer fired early")
+	}
+	tw.advance(3601)
+	if len(tw.C) != 1 || <-tw.C != "far" {
+		t.Fatal("timer did not fire on time")
+	}
+}
+
+func TestTimeWheelRemove(t *testing.T) {
+	tw := newTestWheel(t)
+	tw.AddTimer(2*time.Hour, "a", "a")
+	waitPending(t, tw, 1)
+	tw.RemoveTimer("a")
+	waitPending(t, tw, 0)
+	tw.advance(2*3600 + 1)
+	if len(tw.C) != 0 {
+		t.Fatal("removed timer fired")
+	}
+}
+
+func TestTimeWheelReplace(t *testing.T) {
+	tw := newTestWheel(t)
+	tw.AddTimer(90*time.Second, "a", 1)
+	tw.AddTimer(10*time.Second, "a", 2)
+	waitPending(t, tw, 1)
+	tw.advance(11)
+	if len(tw.C) != 1 || <-tw.C != 2 {
+		t.Fatal("replaced timer did not fire with the new data")
+	}
+	tw.advance(90)
+	if len(tw.C) != 0 {
+		t.Fatal("old timer still fired")
+	}
+}
+
+func TestTimeWheelRemoveAfterCascade(t *testing.T) {
+	tw := newTestWheel(t)
+	tw.AddTimer(90*time.Second, "a", "a")
+	tw.AddTimer(90*time.Second, "b", "b")
+	waitPending(t, tw, 2)
+	tw.advance(60) //降级到第0层
+	tw.RemoveTimer("a")
+	waitPending(t, tw, 1)
+	tw.advance(31)
+	if len(tw.C) != 1 || <-tw.C != "b" {
+		t.Fatal("wrong timer fired after cascade")
+	}
+}
+
+func TestTimeWheelReset(t *testing.T) {
+	tw := newTestWheel(t)
+	tw.AddTimer(10*time.Second, "a", "a")
+	waitPending(t, tw, 1)
+	tw.advance(5)
+	tw.ResetTimer("a", 10*time.Second)
+	tw.ResetTimer("missing", time.Second)
+	tw.AddTimer(time.Hour, "sync", nil) //同一个channel按顺序处理，sync入轮说明前面的操作已完成
+	waitPending(t, tw, 2)
+	tw.advance(10)
+	if len(tw.C) != 0 {
+		t.Fatal("reset timer fired at the old time")
+	}
+	tw.advance(1)
+	if len(tw.C) != 1 || <-tw.C != "a" {
+		t.Fatal("reset timer did not fire")
+	}
+}
+
+func TestTimeWheelPostpone(t *testing.T) {
+	tw := newTestWheel(t)
+	tw.AddTimer(10*time.Second, "a", "a")
+	waitPending(t, tw, 1)
+	tw.advance(5)
+	tw.Postpone("a", 120*time.Second)
+	tw.AddTimer(time.Hour, "sync", nil)
+	waitPending(t, tw, 2)
+	tw.advance(125)
+	if len(tw.C) != 0 {
+		t.Fatal("postponed timer fired early")
+	}
+	tw.advance(1)
+	if len(tw.C) != 1 || <-tw.C != "a" {
+		t.Fatal("postponed timer did not fire")
+	}
+}
+
+func TestTimeWheelBacklog(t *testing.T) {
+	tw := newTestWheel(t, WithTwBuffer(10, 1), WithTwOverflow(SpillOnOverflow))
+	for i := 0; i < 3; i++ {
+		tw.AddTimer(time.Second, i, i)
+	}
+	waitPending(t, tw, 3)
+	tw.advance(2) //C只能放一个，其余的不会阻塞时间轮协程
+	if st := tw.Stats(); st.Backlog != 2 {
+		t.Fatalf("backlog = %d, want 2", st.Backlog)
+	}
+	for i := 0; i < 3; i++ {
+		if v := <-tw.C; v != i {
+			t.Fatalf("got %v, want %d", v, i)
+		}
+	}
+}
+
+func TestTimeWheelBlockLag(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	tw := NewTw(time.Second, 60, nil, WithTwBuffer(10, 1), WithTwClock(clock))
+	tw.Start()
+	defer tw.Stop()
+	tw.AddTimer(time.Second, "a", "a")
+	tw.AddTimer(time.Second, "b", "b")
+	tw.AddTimer(5*time.Second, "c", "c")
+	clock.Advance(10 * time.Second)
+	if lag := tw.Stats().Lag; lag != 8 { //C已满，第2个tick之后暂停前进
+		t.Fatalf("lag = %d, want 8", lag)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if v := <-tw.C; v != want {
+			t.Fatalf("got %v, want %s", v, want)
+		}
+	}
+}
+
+func TestTimeWheelDrop(t *testing.T) {
+	tw := NewTw(time.Second, 60, nil, WithTwBuffer(1, 1), WithTwOverflow(DropOnOverflow), WithTwClock(NewFakeClock(time.Now())))
+	tw.AddTimer(time.Second, "a", "a")
+	tw.AddTimer(time.Second, "b", "b") //协程未启动，channel已满
+	if n := atomic.LoadUint64(&tw.droppedOps); n != 1 {
+		t.Fatalf("dropped ops = %d, want 1", n)
+	}
+	tw.Start()
+	defer tw.Stop()
+	waitPending(t, tw, 1)
+	tw.AddTimer(time.Second, "b", "b")
+	waitPending(t, tw, 2)
+	tw.advance(2)
+	if st := tw.Stats(); st.DroppedFired != 1 || len(tw.C) != 1 {
+		t.Fatalf("stats = %+v, queued = %d", st, len(tw.C))
+	}
+}
+
+func TestTimeWheelSpillOrder(t *testing.T) {
+	tw := NewTw(time.Second, 60, nil, WithTwBuffer(1, 10), WithTwOverflow(SpillOnOverflow), WithTwClock(NewFakeClock(time.Now())))
+	tw.AddTimer(time.Hour, "a", "old")
+	tw.RemoveTimer("a") //协程未启动，之后的操作都进入溢出队列
+	tw.AddTimer(10*time.Second, "a", "new")
+	tw.AddTimer(time.Hour, "b", "b")
+	tw.RemoveTimer("b")
+	tw.Start()
+	defer tw.Stop()
+	waitPending(t, tw, 1)
+	tw.advance(11)
+	if len(tw.C) != 1 || <-tw.C != "new" {
+		t.Fatal("spilled operations ran out of order")
+	}
+}
+
+func TestTimeWheelIntrospection(t *testing.T) {
+	tw := newTestWheel(t)
+	if _, ok := tw.NextExpiry(); ok {
+		t.Fatal("empty wheel has a next expiry")
+	}
+	tw.AddTimer(2*time.Hour, "far", "far")
+	tw.AddTimer(10*time.Second, "near", "near")
+	tw.AddTimer(90*time.Second, "mid", "mid")
+	waitPending(t, tw, 3)
+	if n := tw.Pending(); n != 3 {
+		t.Fatalf("Pending = %d, want 3", n)
+	}
+
+	now := time.Now()
+	info, ok := tw.Lookup("mid")
+	if !ok || info.Data != "mid" || info.Level != 1 {
+		t.Fatalf("Lookup(mid) = %+v, %v", info, ok)
+	}
+	if d := info.At.Sub(now); d < 89*time.Second || d > 92*time.Second {
+		t.Fatalf("mid fires in %v", d)
+	}
+	if _, ok := tw.Lookup("missing"); ok {
+		t.Fatal("Lookup found a missing key")
+	}
+	next, ok := tw.NextExpiry()
+	if !ok || next.Sub(now) > 12*time.Second {
+		t.Fatalf("NextExpiry = %v, %v", next.Sub(now), ok)
+	}
+
+	timers := tw.Timers()
+	if len(timers) != 3 || timers[0].Key != "near" || timers[1].Key != "mid" || timers[2].Key != "far" {
+		t.Fatalf("Timers = %+v", timers)
+	}
+	tw.advance(11)
+	<-tw.C
+	if _, ok := tw.Lookup("near"); ok || tw.Pending() != 2 {
+		t.Fatal("fired timer is still listed")
+	}
+}
+
+func TestCacheTimers(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("name", "城邦", time.Hour, false)
+	c.Set("tmp", 1, time.Minute, false)
+	waitFor(t, func() bool { return len(c.Timers()) == 2 })
+	timers := c.Timers()
+	if fmt.Sprint(timers[0].Key) != "kv:tmp" || fmt.Sprint(timers[1].Key) != "kv:name" {
+		t.Fatalf("Timers = %v, %v", timers[0].Key, timers[1].Key)
+	}
+	c.Del("tmp")
+	waitFor(t, func() bool { return len(c.Timers()) == 1 })
+}
@@ -0,0 +1,354 @@
+package speed
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultTopKWidth = 2048 //Top-K内部sketch默认宽度
+	defaultTopKDepth = 5    //Top-K内部sketch默认深度
+)
+
+// TopKEntry Top-K中的元素及其估算计数
+type TopKEntry struct {
+	Item  string
+	Count uint64
+}
+
+// topKItem 用Count-Min Sketch估算计数，最小堆保留计数最大的k个元素
+type topKItem struct {
+	k      int
+	sketch *cmsItem
+	heap   topKHeap
+	index  map[string]*topKNode
+}
+
+type topKNode struct {
+	TopKEntry
+	pos int
+}
+
+// topKHeap 按计数排序的最小堆 堆顶是k个元素中计数最小的
+type topKHeap []*topKNode
+
+func (h topKHeap) Len() int { return len(h) }
+func (h topKHeap) Less(i, j int) bool {
+	if h[i].Count == h[j].Count {
+		return h[i].Item > h[j].Item
+	}
+	return h[i].Count < h[j].Count
+}
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+func (h *topKHeap) Push(x interface{}) {
+	n := x.(*topKNode)
+	n.pos = len(*h)
+	*h = append(*h, n)
+}
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func newTopKItem(k int, width, depth uint32) *topKItem {
+	return &topKItem{k: k, sketch: newCMSItem(width, depth), index: map[string]*topKNode{}}
+}
+
+// incr 增加计数 元素进入Top-K并挤出原有元素时返回被挤出的元素
+func (t *topKItem) incr(item string, n uint64) (string, bool) {
+	count := t.sketch.incr(item, n)
+	return t.offer(item, count)
+}
+
+func (t *topKItem) offer(item string, count uint64) (string, bool) {
+	if node, ok := t.index[item]; ok {
+		node.Count = count
+		heap.Fix(&t.heap, node.pos)
+		return "", false
+	}
+	if len(t.heap) < t.k {
+		node := &topKNode{TopKEntry: TopKEntry{item, count}}
+		heap.Push(&t.heap, node)
+		t.index[item] = node
+		return "", false
+	}
+	min := t.heap[0]
+	if count <= min.Count {
+		return "", false
+	}
+	delete(t.index, min.Item)
+	expelled := min.Item
+	min.TopKEntry = TopKEntry{item, count}
+	t.index[item] = min
+	heap.Fix(&t.heap, 0)
+	return expelled, true
+}
+
+// list 按计数从大到小排序
+func (t *topKItem) list() []TopKEntry {
+	res := make([]TopKEntry, 0, len(t.heap))
+	for _, node := range t.heap {
+		res = append(res, node.TopKEntry)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count == res[j].Count {
+			return res[i].Item < res[j].Item
+		}
+		return res[i].Count > res[j].Count
+	})
+	return res
+}
+
+// TopKReserve 创建Top-K k为保留的元素数量 width、depth为内部Count-Min Sketch的大小，为0时使用默认值
+func (c *cache) TopKReserve(key string, k int, width, depth uint32) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if k <= 0 {
+		return ErrSketchParams
+	}
+	if width == 0 {
+		width = defaultTopKWidth
+	}
+	if depth == 0 {
+		depth = defaultTopKDepth
+	}
+	c.topk_mu.Lock()
+	if _, ok := c.topKItems[key]; ok {
+		c.topk_mu.Unlock()
+		return ErrSketchExists
+	}
+	c.topKItems[key] = newTopKItem(k, width, depth)
+	c.topk_mu.Unlock()
+	c.stats.set(TypeTopK)
+	c.notify(EventSet, TypeTopK, key)
+	return nil
+}
+
+// TopKAdd 元素计数加1 返回每个元素挤出的元素，没有挤出时为空字符串
+func (c *cache) TopKAdd(key string, items ...string) ([]string, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+	res := make([]string, len(items))
+	c.topk_mu.Lock()
+	t, ok := c.topKItems[key]
+	if !ok {
+		c.topk_mu.Unlock()
+		return nil, ErrNoSketch
+	}
+	for i, item := range items {
+		res[i], _ = t.incr(item, 1)
+	}
+	c.topk_mu.Unlock()
+	c.stats.set(TypeTopK)
+	c.notify(EventUpdate, TypeTopK, key)
+	return res, nil
+}
+
+// TopKIncrBy 增加元素计数 返回被挤出的元素
+func (c *cache) TopKIncrBy(key, item string, n uint64) (string, bool, error) {
+	if c.isClosed() {
+		return "", false, ErrClosed
+	}
+	c.topk_mu.Lock()
+	t, ok := c.topKItems[key]
+	if !ok {
+		c.topk_mu.Unlock()
+		return "", false, ErrNoSketch
+	}
+	expelled, ok := t.incr(item, n)
+	c.topk_mu.Unlock()
+	c.stats.set(TypeTopK)
+	c.notify(EventUpdate, TypeTopK, key)
+	return expelled, ok, nil
+}
+
+// TopKQuery 判断元素是否在Top-K中
+func (c *cache) TopKQuery(key string, items ...string) ([]bool, error) {
+	c.topk_mu.RLock()
+	t, ok := c.topKItems[key]
+	var res []bool
+	if ok {
+		res = make([]bool, len(items))
+		for i, item := range items {
+			_, res[i] = t.index[item]
+		}
+	}
+	c.topk_mu.RUnlock()
+	c.stats.lookup(TypeTopK, ok)
+	if !ok {
+		return nil, ErrNoSketch
+	}
+	return res, nil
+}
+
+// TopKCount 查询元素计数的估算值 元素不在Top-K中也可以查询
+func (c *cache) TopKCount(key string, items ...string) ([]uint64, error) {
+	c.topk_mu.RLock()
+	t, ok := c.topKItems[key]
+	var res []uint64
+	if ok {
+		res = make([]uint64, len(items))
+		for i, item := range items {
+			res[i] = t.sketch.query(item)
+		}
+	}
+	c.topk_mu.RUnlock()
+	c.stats.lookup(TypeTopK, ok)
+	if !ok {
+		return nil, ErrNoSketch
+	}
+	return res, nil
+}
+
+// TopKList 获取Top-K元素 按计数从大到小排序
+func (c *cache) TopKList(key string) ([]TopKEntry, error) {
+	c.topk_mu.RLock()
+	t, ok := c.topKItems[key]
+	var res []TopKEntry
+	if ok {
+		res = t.list()
+	}
+	c.topk_mu.RUnlock()
+	c.stats.lookup(TypeTopK, ok)
+	if !ok {
+		return nil, ErrNoSketch
+	}
+	return res, nil
+}
+
+// TopKMerge 将sources合并写入dest 内部sketch大小必须一致(包括已存在的dest)，k取sources中的最大值，dest不存在时自动创建
+func (c *cache) TopKMerge(dest string, sources ...string) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.topk_mu.Lock()
+	var merged *topKItem
+	for _, key := range sources {
+		t, ok := c.topKItems[key]
+		if !ok {
+			c.topk_mu.Unlock()
+			return ErrNoSketch
+		}
+		if merged == nil {
+			merged = newTopKItem(t.k, t.sketch.width, t.sketch.depth)
+		} else if t.sketch.width != merged.sketch.width || t.sketch.depth != merged.sketch.depth {
+			c.topk_mu.Unlock()
+			return ErrSketchDims
+		}
+		if t.k > merged.k {
+			merged.k = t.k
+		}
+		merged.sketch.merge(t.sketch)
+	}
+	if merged == nil {
+		c.topk_mu.Unlock()
+		return ErrSketchParams
+	}
+	old, ok := c.topKItems[dest]
+	if ok && (old.sketch.width != merged.sketch.width || old.sketch.depth != merged.sketch.depth) {
+		c.topk_mu.Unlock()
+		return ErrSketchDims
+	}
+	//候选元素为各个Top-K的并集，按合并后的sketch重新估算计数
+	for _, key := range sources {
+		for item := range c.topKItems[key].index {
+			merged.offer(item, merged.sketch.query(item))
+		}
+	}
+	c.topKItems[dest] = merged
+	c.topk_mu.Unlock()
+	c.stats.set(TypeTopK)
+	c.notify(writeEvent(ok), TypeTopK, dest)
+	return nil
+}
+
+// TopKDel 删除Top-K
+func (c *cache) TopKDel(key string) bool {
+	if c.isClosed() {
+		return false
+	}
+	c.topk_mu.Lock()
+	_, ok := c.topKItems[key]
+	if ok {
+		delete(c.topKItems, key)
+	}
+	c.topk_mu.Unlock()
+	if ok {
+		c.stats.delete(TypeTopK)
+		c.notify(EventDelete, TypeTopK, key)
+	}
+	return ok
+}
+
+// HotKey 访问最频繁的key
+type HotKey struct {
+	Type  DataType
+	Key   string
+	Count uint64 //估算的访问次数
+}
+
+// hotKeys 统计k-v、hash、集合的读写次数 未开启时为nil
+type hotKeys struct {
+	mu   sync.Mutex
+	topK *topKItem
+}
+
+func newHotKeys(k int) *hotKeys {
+	if k <= 0 {
+		return nil
+	}
+	return &hotKeys{topK: newTopKItem(k, defaultTopKWidth, defaultTopKDepth)}
+}
+
+func (h *hotKeys) touch(t DataType, key string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.topK.incr(t.String()+":"+key, 1)
+	h.mu.Unlock()
+}
+
+func (h *hotKeys) reset() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.topK = newTopKItem(h.topK.k, defaultTopKWidth, defaultTopKDepth)
+	h.mu.Unlock()
+}
+
+// HotKeys 访问最频繁的key 按访问次数从大到小排序，需通过WithHotKeys开启
+func (c *cache) HotKeys() []HotKey {
+	if c.hotKeys == nil {
+		return nil
+	}
+	c.hotKeys.mu.Lock()
+	list := c.hotKeys.topK.list()
+	c.hotKeys.mu.Unlock()
+	res := make([]HotKey, 0, len(list))
+	for _, e := range list {
+		typ, key := parseHotKey(e.Item)
+		res = append(res, HotKey{Type: typ, Key: key, Count: e.Count})
+	}
+	return res
+}
+
+func parseHotKey(item string) (DataType, string) {
+	i := strings.IndexByte(item, ':')
+	for _, t := range []DataType{TypeKV, TypeHash, TypeSet} {
+		if item[:i] == t.String() {
+			return t, item[i+1:]
+		}
+	}
+	return TypeKV, item[i+1:]
+}
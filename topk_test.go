@@ -0,0 +1,85 @@
+package speed
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if err := c.TopKReserve("urls", 3, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		c.TopKAdd("urls", "/home", "/other-"+strconv.Itoa(i))
+		if i%2 == 0 {
+			c.TopKAdd("urls", "/login")
+		}
+	}
+	if _, ok, _ := c.TopKIncrBy("urls", "/api", 80); !ok {
+		t.Fatal("/api should expel an item")
+	}
+	list, _ := c.TopKList("urls")
+	if len(list) != 3 || list[0] != (TopKEntry{"/home", 100}) || list[1] != (TopKEntry{"/api", 80}) || list[2] != (TopKEntry{"/login", 50}) {
+		t.Fatalf("TopKList = %+v", list)
+	}
+	if res, _ := c.TopKQuery("urls", "/home", "/other-1"); !res[0] || res[1] {
+		t.Fatalf("TopKQuery = %v", res)
+	}
+	if res, _ := c.TopKCount("urls", "/other-1"); res[0] != 1 {
+		t.Fatalf("TopKCount = %v", res)
+	}
+
+	c.TopKReserve("urls2", 3, 0, 0)
+	c.TopKIncrBy("urls2", "/login", 100)
+	c.TopKIncrBy("urls2", "/search", 60)
+	if err := c.TopKMerge("all", "urls", "urls2"); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = c.TopKList("all")
+	if len(list) != 3 || list[0] != (TopKEntry{"/login", 150}) || list[1].Item != "/home" || list[2].Item != "/api" {
+		t.Fatalf("merged list = %+v", list)
+	}
+	c.TopKReserve("small", 3, 10, 2)
+	if err := c.TopKMerge("small", "urls", "urls2"); err != ErrSketchDims {
+		t.Fatalf("err = %v, want ErrSketchDims", err)
+	}
+	if list, _ := c.TopKList("small"); len(list) != 0 {
+		t.Fatalf("dest was replaced: %+v", list)
+	}
+	if _, err := c.TopKAdd("missing", "x"); err != ErrNoSketch {
+		t.Fatalf("err = %v, want ErrNoSketch", err)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	c, err := New(WithHotKeys(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("config", 1, 0, false)
+	for i := 0; i < 10; i++ {
+		c.Get("config")
+		c.HGetAll("userinfo")
+		c.Get("key-" + strconv.Itoa(i))
+	}
+	c.Get("config")
+	hot := c.HotKeys()
+	if len(hot) != 2 || hot[0] != (HotKey{TypeKV, "config", 12}) || hot[1] != (HotKey{TypeHash, "userinfo", 10}) {
+		t.Fatalf("HotKeys = %+v", hot)
+	}
+	c.ResetStats()
+	if len(c.HotKeys()) != 0 {
+		t.Fatal("ResetStats did not clear hot keys")
+	}
+	c2, _ := New()
+	defer c2.Stop()
+	if c2.HotKeys() != nil {
+		t.Fatal("hot keys should be disabled by default")
+	}
+}
@@ -0,0 +1,338 @@
+package speed
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Tx 事务 命令先入队，Exec时按kv、hash、set的固定顺序加锁后依次执行，任意命令失败则全部回滚
+type Tx struct {
+	c       *cache
+	cmds    []txCmd
+	watches []txWatch
+}
+
+// txWatch 监视的key以及监视时的版本号 key不存在时为当时的全局版本号
+type txWatch struct {
+	typ     DataType
+	key     string
+	exists  bool
+	version uint64
+}
+
+// txCmd 事务命令，在持有全部写锁时执行
+// 返回值依次为: 命令结果、回滚函数、释放锁之后执行的函数、错误
+type txCmd func() (interface{}, func(), func(), error)
+
+// Multi 开启事务
+func (c *cache) Multi() *Tx {
+	return &Tx{c: c}
+}
+
+// Set 结果为nil
+func (tx *Tx) Set(k string, v interface{}, d time.Duration, callBack bool) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		var endTime int64
+		if d > 0 {
+			endTime = tx.c.clock.Now().Add(d).Unix()
+		}
+		undo := tx.c.kvUndo(k)
+		after := tx.c.kvStore(KVItem{Object: v, Expiration: endTime, CallBack: callBack, Key: k}, d)
+		return nil, undo, after, nil
+	})
+}
+
+// Get 结果为值，key不存在时为nil
+func (tx *Tx) Get(k string) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		item, ok := tx.c.kvItems[k]
+		ok = ok && !item.expired(tx.c.clock.Now().Unix())
+		after := func() { tx.c.stats.lookup(TypeKV, ok) }
+		if !ok {
+			return nil, nil, after, nil
+		}
+		return kvCopy(item.Object), nil, after, nil
+	})
+}
+
+// Del 结果为bool，表示key是否存在
+func (tx *Tx) Del(k string) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.kvUndo(k)
+		after, ok := tx.c.kvDel(k)
+		return ok, undo, after, nil
+	})
+}
+
+// IncrBy 结果为自增后的int64，值不是整数时整个事务失败
+func (tx *Tx) IncrBy(k string, n int64) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.kvUndo(k)
+		v, after, err := tx.c.kvIncrBy(k, n)
+		return v, undo, after, err
+	})
+}
+
+// HSet 结果为nil
+func (tx *Tx) HSet(key, field string, val interface{}) *Tx {
+	return tx.HMSet(key, map[string]interface{}{field: val})
+}
+
+// HMSet 结果为nil
+func (tx *Tx) HMSet(key string, data map[string]interface{}) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.hashUndo(key)
+		after := tx.c.hashStore(key, data)
+		return nil, undo, after, nil
+	})
+}
+
+// HGetAll 结果为map[string]interface{}
+func (tx *Tx) HGetAll(key string) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		hash, ok := tx.c.hashItems[key]
+		res := make(map[string]interface{}, len(hash.Object))
+		for field, val := range hash.Object {
+			res[field] = val
+		}
+		return res, nil, func() { tx.c.stats.lookup(TypeHash, ok) }, nil
+	})
+}
+
+// HDel 结果为bool，表示是否删除了key或字段
+func (tx *Tx) HDel(key string, fields ...string) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.hashUndo(key)
+		after, ok := tx.c.hashDel(key, fields...)
+		return ok, undo, after, nil
+	})
+}
+
+// HIncrBy 结果为自增后的int64，字段值不是整数时整个事务失败
+func (tx *Tx) HIncrBy(key, field string, n int64) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.hashUndo(key)
+		v, after, err := tx.c.hashIncrBy(key, field, n)
+		return v, undo, after, err
+	})
+}
+
+// SAdd 结果为nil
+func (tx *Tx) SAdd(key string, d time.Duration, callBack bool, members ...interface{}) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		if len(members) == 0 {
+			return nil, nil, nil, nil
+		}
+		undo := tx.c.setUndo(key)
+		after := tx.c.setAdd(key, d, callBack, members...)
+		return nil, undo, after, nil
+	})
+}
+
+// SRem 结果为删除的成员个数
+func (tx *Tx) SRem(key string, members ...interface{}) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		undo := tx.c.setUndo(key)
+		n, after := tx.c.setRem(key, members...)
+		return n, undo, after, nil
+	})
+}
+
+// SISMembers 结果为bool
+func (tx *Tx) SISMembers(key string, member interface{}) *Tx {
+	return tx.queue(func() (interface{}, func(), func(), error) {
+		setItem, ok := tx.c.setItems[key]
+		if ok {
+			_, ok = setItem.Object[member]
+		}
+		return ok, nil, func() { tx.c.stats.lookup(TypeSet, ok) }, nil
+	})
+}
+
+// Watch 监视k-v，Exec时任意监视的key版本发生变化(包括被删除或过期)则整个事务放弃执行
+// 监视时不存在的key被创建，或之后有任意k-v、hash被删除(可能是先创建再删除)，同样放弃执行
+func (tx *Tx) Watch(keys ...string) *Tx {
+	tx.c.kv_mu.RLock()
+	for _, k := range keys {
+		item, ok := tx.c.kvItems[k]
+		tx.watches = append(tx.watches, tx.c.watch(TypeKV, k, ok, item.Version))
+	}
+	tx.c.kv_mu.RUnlock()
+	return tx
+}
+
+// WatchHash 监视hash，规则同Watch
+func (tx *Tx) WatchHash(keys ...string) *Tx {
+	tx.c.hash_mu.RLock()
+	for _, key := range keys {
+		hash, ok := tx.c.hashItems[key]
+		tx.watches = append(tx.watches, tx.c.watch(TypeHash, key, ok, hash.Version))
+	}
+	tx.c.hash_mu.RUnlock()
+	return tx
+}
+
+// watch 生成监视记录 key不存在时记录当前的全局版本号
+func (c *cache) watch(typ DataType, key string, exists bool, version uint64) txWatch {
+	if !exists {
+		version = atomic.LoadUint64(&c.version)
+	}
+	return txWatch{typ: typ, key: key, exists: exists, version: version}
+}
+
+// changed 判断监视的key是否被修改 调用方需持有全部写锁
+func (tx *Tx) changed() bool {
+	for _, w := range tx.watches {
+		var version uint64
+		var ok bool
+		switch w.typ {
+		case TypeKV:
+			var item KVItem
+			item, ok = tx.c.kvItems[w.key]
+			version = item.Version
+		case TypeHash:
+			var hash HASHItem
+			hash, ok = tx.c.hashItems[w.key]
+			version = hash.Version
+		}
+		if ok != w.exists {
+			return true
+		}
+		if ok && version != w.version {
+			return true
+		}
+		if !ok && atomic.LoadUint64(&tx.c.delVersion) > w.version {
+			return true
+		}
+	}
+	return false
+}
+
+func (tx *Tx) queue(cmd txCmd) *Tx {
+	tx.cmds = append(tx.cmds, cmd)
+	return tx
+}
+
+// Discard 丢弃已入队的命令并取消监视
+func (tx *Tx) Discard() {
+	tx.cmds = nil
+	tx.watches = nil
+}
+
+// Exec 执行全部命令并按入队顺序返回每条命令的结果
+// 任意命令出错时已执行的命令全部回滚，返回的错误包含出错命令的序号
+// 监视的key被修改时不执行任何命令，返回ErrTxAborted
+func (tx *Tx) Exec() ([]interface{}, error) {
+	cmds := tx.cmds
+	c := tx.c
+	if c.isClosed() {
+		tx.Discard()
+		return nil, ErrClosed
+	}
+	results := make([]interface{}, len(cmds))
+	undos := make([]func(), 0, len(cmds))
+	afters := make([]func(), 0, len(cmds))
+
+	c.lockAll()
+	changed := tx.changed()
+	tx.Discard()
+	if changed {
+		c.unlockAll()
+		return nil, ErrTxAborted
+	}
+	for i, cmd := range cmds {
+		res, undo, after, err := cmd()
+		if undo != nil {
+			undos = append(undos, undo)
+		}
+		if err != nil {
+			for j := len(undos) - 1; j >= 0; j-- {
+				undos[j]()
+			}
+			c.unlockAll()
+			return nil, fmt.Errorf("tx command %d: %w", i, err)
+		}
+		if after != nil {
+			afters = append(afters, after)
+		}
+		results[i] = res
+	}
+	c.unlockAll()
+
+	for _, after := range afters {
+		after()
+	}
+	return results, nil
+}
+
+// lockAll 按kv、hash、set的固定顺序加写锁，避免多个事务之间死锁
+func (c *cache) lockAll() {
+	c.kv_mu.Lock()
+	c.hash_mu.Lock()
+	c.set_mu.Lock()
+}
+
+func (c *cache) unlockAll() {
+	c.set_mu.Unlock()
+	c.hash_mu.Unlock()
+	c.kv_mu.Unlock()
+}
+
+// kvUndo 记录k-v当前状态，返回恢复函数 调用方需持有kv_mu写锁
+func (c *cache) kvUndo(k string) func() {
+	old, ok := c.kvItems[k]
+	return func() {
+		if ok {
+			c.kvItems[k] = old
+		} else {
+			delete(c.kvItems, k)
+		}
+	}
+}
+
+// hashUndo 记录hash当前状态，返回恢复函数 调用方需持有hash_mu写锁
+func (c *cache) hashUndo(key string) func() {
+	old, ok := c.hashItems[key]
+	if !ok {
+		return func() {
+			delete(c.hashItems, key)
+		}
+	}
+	fields := make(map[string]interface{}, len(old.Object))
+	for field, val := range old.Object {
+		fields[field] = val
+	}
+	return func() {
+		for field := range old.Object {
+			delete(old.Object, field)
+		}
+		for field, val := range fields {
+			old.Object[field] = val
+		}
+		c.hashItems[key] = old
+	}
+}
+
+// setUndo 记录集合当前状态，返回恢复函数 调用方需持有set_mu写锁
+func (c *cache) setUndo(key string) func() {
+	old, ok := c.setItems[key]
+	if !ok {
+		return func() {
+			delete(c.setItems, key)
+		}
+	}
+	members := make(map[interface{}]Set, len(old.Object))
+	for member, item := range old.Object {
+		members[member] = item
+	}
+	return func() {
+		for member := range old.Object {
+			delete(old.Object, member)
+		}
+		for member, item := range members {
+			old.Object[member] = item
+		}
+		c.setItems[key] = old
+	}
+}
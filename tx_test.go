@@ -0,0 +1,132 @@
+package speed
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTxExec(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("counter", 10, 0, false)
+	res, err := c.Multi().
+		IncrBy("counter", 5).
+		HSet("userinfo", "name", "城邦").
+		HIncrBy("userinfo", "visits", 1).
+		SAdd("online", time.Minute, false, 1001).
+		SRem("online", 1002).
+		Get("counter").
+		Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0] != int64(15) || res[2] != int64(1) || res[4] != 0 || res[5] != int64(15) {
+		t.Fatalf("unexpected results %v", res)
+	}
+	if !c.SISMembers("online", 1001) || c.HGetAll("userinfo")["name"] != "城邦" {
+		t.Fatal("tx writes not applied")
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("counter", 1, 0, false)
+	c.HSet("userinfo", "name", "城邦")
+	before := c.Stats()
+	_, err = c.Multi().
+		IncrBy("counter", 1).
+		HSet("userinfo", "name", "speed").
+		HDel("userinfo").
+		SAdd("online", 0, false, 1001).
+		Set("name", "城邦", 0, false).
+		IncrBy("name", 1).
+		Exec()
+	if !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("err = %v, want ErrNotInteger", err)
+	}
+	if after := c.Stats(); after.Total() != before.Total() {
+		t.Fatalf("rolled back commands changed stats: %+v", after.Total())
+	}
+	if v, _ := c.Get("counter"); v != 1 {
+		t.Fatalf("counter = %v, want 1", v)
+	}
+	if v := c.HGetAll("userinfo")["name"]; v != "城邦" {
+		t.Fatalf("hash field = %v, want 城邦", v)
+	}
+	if c.Exists("name") || c.SCard("online") != 0 {
+		t.Fatal("rolled back writes are still visible")
+	}
+}
+
+func TestTxWatch(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.Set("balance", 100, 0, false)
+	c.HSet("userinfo", "name", "城邦")
+
+	tx := c.Multi().Watch("balance").WatchHash("userinfo")
+	c.HSet("userinfo", "age", 30)
+	if _, err := tx.IncrBy("balance", -10).Exec(); err != ErrTxAborted {
+		t.Fatalf("err = %v, want ErrTxAborted", err)
+	}
+	if v, _ := c.Get("balance"); v != 100 {
+		t.Fatalf("balance = %v, want 100", v)
+	}
+
+	tx = c.Multi().Watch("missing").WatchHash("missing")
+	c.Set("missing", 1, 0, false)
+	c.Del("missing") //创建后又删除
+	if _, err := tx.Set("missing", 2, 0, false).Exec(); err != ErrTxAborted {
+		t.Fatalf("err = %v, want ErrTxAborted", err)
+	}
+	tx = c.Multi().WatchHash("missing")
+	c.HSet("missing", "name", "城邦")
+	if _, err := tx.Exec(); err != ErrTxAborted {
+		t.Fatalf("err = %v, want ErrTxAborted", err)
+	}
+
+	tx = c.Multi().Watch("balance", "missing")
+	if _, err := tx.IncrBy("balance", -10).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("balance"); v != int64(90) {
+		t.Fatalf("balance = %v, want 90", v)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if !c.CompareAndSwap("key", 0, 1) {
+		t.Fatal("CompareAndSwap on missing key with version 0 failed")
+	}
+	v, version, ok := c.GetWithVersion("key")
+	if !ok || v != 1 || version == 0 {
+		t.Fatalf("GetWithVersion = %v, %d, %v", v, version, ok)
+	}
+	c.Set("key", 2, 0, false)
+	if c.CompareAndSwap("key", version, 3) {
+		t.Fatal("CompareAndSwap succeeded with a stale version")
+	}
+	_, version, _ = c.GetWithVersion("key")
+	if !c.CompareAndSwap("key", version, 3) {
+		t.Fatal("CompareAndSwap failed with the current version")
+	}
+	if v, _ := c.Get("key"); v != 3 {
+		t.Fatalf("key = %v, want 3", v)
+	}
+}